@@ -3,10 +3,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	// docs is generated by Swag CLI, you have to import it.
@@ -21,6 +25,14 @@ type Api struct {
 	*gin.Engine
 }
 
+// apiCrossOriginPaths are called by the script injected to pages
+var apiCrossOriginPaths = map[string]bool{"/log": true}
+
+func sameOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, host)
+}
+
 func NewApi() (*Api, error) {
 	docs.SwaggerInfo.Title = "Swagger API"
 	docs.SwaggerInfo.Description = ""
@@ -35,14 +47,17 @@ func NewApi() (*Api, error) {
 	r := &Api{gin.Default()}
 
 	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set(
-			"Access-Control-Allow-Headers",
-			"Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With",
-		)
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
-		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+		if origin := c.GetHeader("Origin"); origin != "" && !sameOrigin(origin, c.Request.Host) {
+			// pages can't change rules or send requests through the api, only log
+			if !apiCrossOriginPaths[c.Request.URL.Path] {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "cross-origin request to " + c.Request.URL.Path})
+				return
+			}
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+			c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+		}
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -62,6 +77,46 @@ func NewApi() (*Api, error) {
 	r.GET("/infoPages", r.infoPagesHandler)
 	r.POST("/navigatePage", r.navigatePageHandler)
 	r.POST("/log", r.logHandler)
+	r.GET("/ca.pem", r.caPEMHandler)
+	r.GET("/ca.der", r.caDERHandler)
+	r.GET("/websocket/conversations", r.wsConversationsHandler)
+	r.GET("/websocket/frames", r.wsFramesHandler)
+	r.GET("/redirects", r.redirectsHandler)
+	r.GET("/cacheMode", r.getCacheModeHandler)
+	r.PUT("/cacheMode", r.putCacheModeHandler)
+	r.GET("/scope", r.getScopeHandler)
+	r.PUT("/scope", r.putScopeHandler)
+	r.GET("/replace", r.getReplaceHandler)
+	r.PUT("/replace", r.putReplaceHandler)
+	r.PUT("/replaceToggle", r.replaceToggleHandler)
+	r.GET("/map", r.getMapHandler)
+	r.PUT("/map", r.putMapHandler)
+	r.GET("/routes", r.getRoutesHandler)
+	r.PUT("/routes", r.putRoutesHandler)
+	r.GET("/upstreams", r.upstreamsHandler)
+	r.GET("/intercept", r.getInterceptHandler)
+	r.PUT("/intercept", r.putInterceptHandler)
+	r.GET("/intercept/queue", r.interceptQueueHandler)
+	r.GET("/intercept/queue/:id", r.interceptItemHandler)
+	r.POST("/intercept/queue/:id/forward", r.interceptForwardHandler)
+	r.POST("/intercept/queue/:id/drop", r.interceptDropHandler)
+	r.GET("/intercept/stream", r.interceptStreamHandler)
+	r.GET("/relax", r.getRelaxHandler)
+	r.PUT("/relax", r.putRelaxHandler)
+	r.PUT("/relaxToggle", r.relaxToggleHandler)
+	r.GET("/har", r.exportHARHandler)
+	r.POST("/har", r.importHARHandler)
+	r.GET("/history", r.historyHandler)
+	r.GET("/history/:id", r.historyEntryHandler)
+	r.GET("/history/:id/events", r.historyEventsHandler)
+	r.POST("/repeat", r.repeatHandler)
+	r.POST("/intruder", r.startIntruderHandler)
+	r.GET("/intruder", r.intruderRunsHandler)
+	r.GET("/intruder/:id", r.intruderRunHandler)
+	r.DELETE("/intruder/:id", r.cancelIntruderHandler)
+	r.GET("/findings", r.findingsHandler)
+	r.GET("/passiveChecks", r.passiveChecksHandler)
+	r.GET("/csp", r.cspHandler)
 
 	return r, nil
 }
@@ -72,7 +127,7 @@ func NewApi() (*Api, error) {
 // @Router /config [get]
 // @Success 200 {string} string "answer"
 func (a Api) configHandler(ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, options)
+	ctx.JSON(http.StatusOK, options.Redacted())
 }
 
 // Config godoc
@@ -153,3 +208,710 @@ func (a Api) logHandler(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, struct{}{})
 	return
 }
+
+// Config godoc
+// @Produce application/x-pem-file
+// @Router /ca.pem [get]
+// @Success 200 {string} string "MITM CA certificate in PEM"
+func (a Api) caPEMHandler(ctx *gin.Context) {
+	ctx.Header("Content-Disposition", "attachment; filename=anothergoproxy-ca.pem")
+	ctx.Data(http.StatusOK, "application/x-pem-file", proxy.ca.PEM())
+}
+
+// Config godoc
+// @Produce application/x-x509-ca-cert
+// @Router /ca.der [get]
+// @Success 200 {string} string "MITM CA certificate in DER"
+func (a Api) caDERHandler(ctx *gin.Context) {
+	ctx.Header("Content-Disposition", "attachment; filename=anothergoproxy-ca.der")
+	ctx.Data(http.StatusOK, "application/x-x509-ca-cert", proxy.ca.DER())
+}
+
+// Config godoc
+// @Produce json
+// @Param session query integer false "goproxy session id"
+// @Param hash query string false "handshake request hash"
+// @Router /websocket/conversations [get]
+// @Success 200 {string} string "answer"
+func (a Api) wsConversationsHandler(ctx *gin.Context) {
+	session, err := strconv.ParseInt(ctx.DefaultQuery("session", "-1"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	convs, err := proxy.cacheHandlers.wsRelay.store.Conversations(session, ctx.Query("hash"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": convs})
+}
+
+// Config godoc
+// @Produce json
+// @Param id query string false "conversation id"
+// @Param session query integer false "goproxy session id"
+// @Param direction query string false "client or server"
+// @Param opcode query integer false "frame opcode"
+// @Router /websocket/frames [get]
+// @Success 200 {string} string "answer"
+func (a Api) wsFramesHandler(ctx *gin.Context) {
+	store := proxy.cacheHandlers.wsRelay.store
+	ids := make([]string, 0)
+	if id := ctx.Query("id"); id != "" {
+		ids = append(ids, id)
+	} else if sessionStr := ctx.Query("session"); sessionStr != "" {
+		session, err := strconv.ParseInt(sessionStr, 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		convs, err := store.Conversations(session, "")
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, conv := range convs {
+			ids = append(ids, conv.ID)
+		}
+	} else {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "id or session is required"})
+		return
+	}
+	opcode, err := strconv.Atoi(ctx.DefaultQuery("opcode", "-1"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res := make([]*WSFrame, 0)
+	for _, id := range ids {
+		_, frames, err := store.Load(id, true)
+		if err != nil {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		for _, f := range frames {
+			if direction := ctx.Query("direction"); direction != "" && f.Direction != direction {
+				continue
+			}
+			if opcode >= 0 && f.Opcode != opcode {
+				continue
+			}
+			res = append(res, f)
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": res})
+}
+
+// Config godoc
+// @Produce json
+// @Param url query string false "start or final url contains"
+// @Router /redirects [get]
+// @Success 200 {string} string "answer"
+func (a Api) redirectsHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.redirects.Chains(ctx.Query("url"))})
+}
+
+// Config godoc
+// @Produce json
+// @Router /cacheMode [get]
+// @Success 200 {string} string "answer"
+func (a Api) getCacheModeHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.modes.Snapshot()})
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param modes body CacheModes true "default mode, ordered url regexp rules and fuzzy match url regexps"
+// @Router /cacheMode [put]
+// @Success 200 {string} string "answer"
+func (a Api) putCacheModeHandler(ctx *gin.Context) {
+	req := struct {
+		Default CacheMode        `json:"default" binding:"required"`
+		Rules   []*CacheModeRule `json:"rules"`
+		Fuzzy   []string         `json:"fuzzy"`
+	}{}
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := proxy.cacheHandlers.modes.Set(req.Default, req.Rules, req.Fuzzy); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.modes.Snapshot()})
+}
+
+// Config godoc
+// @Produce json
+// @Router /scope [get]
+// @Success 200 {string} string "answer"
+func (a Api) getScopeHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": scope.Snapshot()})
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param scope body Scope true "ordered include/exclude rules, saved to the --scope file"
+// @Router /scope [put]
+// @Success 200 {string} string "answer"
+func (a Api) putScopeHandler(ctx *gin.Context) {
+	req := Scope{}
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := scope.Set(req.Rules); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": scope.Snapshot()})
+}
+
+// Config godoc
+// @Produce json
+// @Router /replace [get]
+// @Success 200 {string} string "answer"
+func (a Api) getReplaceHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.replacer.Rules()})
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param rules body []ReplaceRule true "ordered match and replace rules"
+// @Router /replace [put]
+// @Success 200 {string} string "answer"
+func (a Api) putReplaceHandler(ctx *gin.Context) {
+	rules := make([]*ReplaceRule, 0)
+	if err := ctx.BindJSON(&rules); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := proxy.cacheHandlers.replacer.Set(rules); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.replacer.Rules()})
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param toggle body object true "rule id and enabled"
+// @Router /replaceToggle [put]
+// @Success 200 {string} string "answer"
+func (a Api) replaceToggleHandler(ctx *gin.Context) {
+	req := struct {
+		ID      string `json:"id" binding:"required"`
+		Enabled bool   `json:"enabled"`
+	}{}
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := proxy.cacheHandlers.replacer.Toggle(req.ID, req.Enabled); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.replacer.Rules()})
+}
+
+// Config godoc
+// @Produce json
+// @Router /map [get]
+// @Success 200 {string} string "answer"
+func (a Api) getMapHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.maps.Snapshot()})
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param rules body MapRules true "map local and map remote rules"
+// @Router /map [put]
+// @Success 200 {string} string "answer"
+func (a Api) putMapHandler(ctx *gin.Context) {
+	req := MapRules{}
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := proxy.cacheHandlers.maps.Set(req.Local, req.Remote); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.maps.Snapshot()})
+}
+
+// Config godoc
+// @Produce json
+// @Router /routes [get]
+// @Success 200 {string} string "answer"
+func (a Api) getRoutesHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.router.Redacted()})
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param routes body Router true "upstreams, routes and health check"
+// @Router /routes [put]
+// @Success 200 {string} string "answer"
+func (a Api) putRoutesHandler(ctx *gin.Context) {
+	req := Router{}
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := proxy.router.Set(req.Upstreams, req.Routes, req.HealthCheck); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.router.Redacted()})
+}
+
+// Config godoc
+// @Produce json
+// @Router /upstreams [get]
+// @Success 200 {array} UpstreamHealth
+func (a Api) upstreamsHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.router.Health()})
+}
+
+// Config godoc
+// @Produce json
+// @Router /intercept [get]
+// @Success 200 {string} string "answer"
+func (a Api) getInterceptHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.interceptor.Config()})
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param config body InterceptConfig true "what to hold, scope rules and timeout in seconds"
+// @Router /intercept [put]
+// @Success 200 {string} string "answer"
+func (a Api) putInterceptHandler(ctx *gin.Context) {
+	req := InterceptConfig{}
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := proxy.cacheHandlers.interceptor.SetConfig(req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.interceptor.Config()})
+}
+
+// Config godoc
+// @Produce json
+// @Router /intercept/queue [get]
+// @Success 200 {string} string "answer"
+func (a Api) interceptQueueHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.interceptor.Queue()})
+}
+
+// Config godoc
+// @Produce json
+// @Param id path int true "item id"
+// @Router /intercept/queue/{id} [get]
+// @Success 200 {string} string "answer"
+func (a Api) interceptItemHandler(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	item, ok := proxy.cacheHandlers.interceptor.Get(id)
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no such item"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": item})
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param id path int true "item id"
+// @Param edit body object false "raw: edited http message, empty forwards it unchanged"
+// @Router /intercept/queue/{id}/forward [post]
+// @Success 200 {string} string "answer"
+func (a Api) interceptForwardHandler(ctx *gin.Context) {
+	req := struct {
+		Raw string `json:"raw"`
+	}{}
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	a.interceptDecide(ctx, InterceptForward, req.Raw)
+}
+
+// Config godoc
+// @Produce json
+// @Param id path int true "item id"
+// @Router /intercept/queue/{id}/drop [post]
+// @Success 200 {string} string "answer"
+func (a Api) interceptDropHandler(ctx *gin.Context) {
+	a.interceptDecide(ctx, InterceptDrop, "")
+}
+
+func (a Api) interceptDecide(ctx *gin.Context, action InterceptAction, raw string) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := proxy.cacheHandlers.interceptor.Decide(id, action, raw); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, struct{}{})
+}
+
+// Config godoc
+// @Produce text/event-stream
+// @Router /intercept/stream [get]
+// @Success 200 {string} string "server-sent events: item"
+func (a Api) interceptStreamHandler(ctx *gin.Context) {
+	items, cancel := proxy.cacheHandlers.interceptor.Subscribe()
+	defer cancel()
+	// items held before the client connected
+	for _, item := range proxy.cacheHandlers.interceptor.Queue() {
+		ctx.SSEvent("item", item)
+	}
+	ctx.Writer.Flush()
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case item := <-items:
+			ctx.SSEvent("item", item)
+			return true
+		case <-ctx.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// Config godoc
+// @Produce json
+// @Router /relax [get]
+// @Success 200 {string} string "answer"
+func (a Api) getRelaxHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.relax.Snapshot()})
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param policies body RelaxPolicies true "global switch and ordered host/path policies"
+// @Router /relax [put]
+// @Success 200 {string} string "answer"
+func (a Api) putRelaxHandler(ctx *gin.Context) {
+	req := RelaxPolicies{Enabled: true}
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := proxy.relax.Set(req.Enabled, req.Policies); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.relax.Snapshot()})
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param toggle body object true "policy name (empty switches all relaxations) and enabled"
+// @Router /relaxToggle [put]
+// @Success 200 {string} string "answer"
+func (a Api) relaxToggleHandler(ctx *gin.Context) {
+	req := struct {
+		Policy  string `json:"policy"`
+		Enabled bool   `json:"enabled"`
+	}{}
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := proxy.relax.Toggle(req.Policy, req.Enabled); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.relax.Snapshot()})
+}
+
+// Config godoc
+// @Produce json
+// @Param host query string false "host regexp"
+// @Param from query string false "RFC3339 time, inclusive"
+// @Param to query string false "RFC3339 time, exclusive"
+// @Router /har [get]
+// @Success 200 {object} HAR
+func (a Api) exportHARHandler(ctx *gin.Context) {
+	filter, err := NewHARFilter(ctx.Query("host"), ctx.Query("from"), ctx.Query("to"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	har, err := ExportHAR(proxy.cacheHandlers.cache, filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Header("Content-Disposition", "attachment; filename=anothergoproxy.har")
+	ctx.JSON(http.StatusOK, har)
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param har body HAR true "HAR to store in the cache"
+// @Router /har [post]
+// @Success 200 {string} string "answer"
+func (a Api) importHARHandler(ctx *gin.Context) {
+	har := &HAR{}
+	if err := ctx.BindJSON(har); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	n, err := ImportHAR(proxy.cacheHandlers.cache, har)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "imported": n})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": n})
+}
+
+// Config godoc
+// @Produce json
+// @Param method query string false "request method"
+// @Param host query string false "host"
+// @Param path query string false "path regexp"
+// @Param status query integer false "response status"
+// @Param from query string false "RFC3339 time, inclusive"
+// @Param to query string false "RFC3339 time, exclusive"
+// @Param offset query integer false "skip first entries"
+// @Param limit query integer false "page size, 100 by default"
+// @Router /history [get]
+// @Success 200 {string} string "answer"
+func (a Api) historyHandler(ctx *gin.Context) {
+	filter := HistoryFilter{
+		Method: ctx.Query("method"),
+		Host:   ctx.Query("host"),
+		Path:   ctx.Query("path"),
+	}
+	var err error
+	for _, p := range []struct {
+		name  string
+		value *int
+		def   string
+	}{
+		{"status", &filter.Status, "0"},
+		{"offset", &filter.Offset, "0"},
+		{"limit", &filter.Limit, "100"},
+	} {
+		if *p.value, err = strconv.Atoi(ctx.DefaultQuery(p.name, p.def)); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": p.name + ": " + err.Error()})
+			return
+		}
+	}
+	if filter.From, filter.To, err = parseTimeRange(ctx.Query("from"), ctx.Query("to")); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entries, more, err := proxy.cacheHandlers.history.Find(filter)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": entries, "more": more})
+}
+
+// Config godoc
+// @Produce json
+// @Param id path integer true "history id"
+// @Router /history/{id} [get]
+// @Success 200 {object} HistoryEntry
+func (a Api) historyEntryHandler(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entry, err := proxy.cacheHandlers.history.Get(id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": entry})
+}
+
+// Config godoc
+// @Produce json
+// @Param id path integer true "history id"
+// @Router /history/{id}/events [get]
+// @Success 200 {array} SSEEvent
+func (a Api) historyEventsHandler(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := LoadSSEEvents(id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": events})
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param repeat body RepeatEdits true "id (history id) or raw request, scheme for raw origin-form requests (https by default), edits"
+// @Router /repeat [post]
+// @Success 200 {object} RepeatResult
+func (a Api) repeatHandler(ctx *gin.Context) {
+	req := struct {
+		ID     uint64 `json:"id"`
+		Raw    string `json:"raw"`
+		Scheme string `json:"scheme"`
+		RepeatEdits
+	}{Scheme: "https"}
+	if err := ctx.BindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var base *RequestDTO
+	var err error
+	switch {
+	case req.Raw != "":
+		base, err = ParseRawRequest(req.Raw, req.Scheme)
+	case req.ID != 0:
+		base, err = proxy.cacheHandlers.history.Request(req.ID)
+	default:
+		err = errors.New("id or raw is required")
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	edited, err := req.Edit(base)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	res, err := proxy.repeater.Repeat(edited, req.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": res})
+}
+
+// Config godoc
+// @Accept json
+// @Produce json
+// @Param attack body IntruderAttack true "base request (history id or raw with §markers§), positions, mode, payload sets, concurrency, rate, grep"
+// @Router /intruder [post]
+// @Success 200 {object} IntruderRun
+func (a Api) startIntruderHandler(ctx *gin.Context) {
+	attack := &IntruderAttack{}
+	if err := ctx.BindJSON(attack); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	run, err := proxy.intruder.Start(attack)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": run.Snapshot(false)})
+}
+
+// Config godoc
+// @Produce json
+// @Router /intruder [get]
+// @Success 200 {string} string "answer"
+func (a Api) intruderRunsHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.intruder.Runs()})
+}
+
+// Config godoc
+// @Produce json
+// @Param id path string true "run id"
+// @Router /intruder/{id} [get]
+// @Success 200 {object} IntruderRun
+func (a Api) intruderRunHandler(ctx *gin.Context) {
+	run, ok := proxy.intruder.Run(ctx.Param("id"))
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no such run"})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": run.Snapshot(true)})
+}
+
+// Config godoc
+// @Produce json
+// @Param id path string true "run id"
+// @Router /intruder/{id} [delete]
+// @Success 200 {string} string "answer"
+func (a Api) cancelIntruderHandler(ctx *gin.Context) {
+	if !proxy.intruder.Cancel(ctx.Param("id")) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no such run"})
+		return
+	}
+	ctx.JSON(http.StatusOK, struct{}{})
+}
+
+// Config godoc
+// @Produce json
+// @Param type query string false "reflection or passive check name"
+// @Param severity query string false "info, low, medium or high"
+// @Param host query string false "host"
+// @Param path query string false "path regexp"
+// @Param context query string false "reflection context: html, attribute, script, json or header"
+// @Router /findings [get]
+// @Success 200 {string} string "answer"
+func (a Api) findingsHandler(ctx *gin.Context) {
+	findings, err := proxy.cacheHandlers.findings.Find(FindingFilter{
+		Type:     ctx.Query("type"),
+		Severity: ctx.Query("severity"),
+		Host:     ctx.Query("host"),
+		Path:     ctx.Query("path"),
+		Context:  ctx.Query("context"),
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"result": findings})
+}
+
+// Config godoc
+// @Produce json
+// @Router /passiveChecks [get]
+// @Success 200 {string} string "answer"
+func (a Api) passiveChecksHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.scanner.Checks()})
+}
+
+// Config godoc
+// @Produce json
+// @Param origin query string false "scheme://host[:port], empty is all origins"
+// @Router /csp [get]
+// @Success 200 {string} string "answer"
+func (a Api) cspHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"result": proxy.cacheHandlers.csp.Origins(ctx.Query("origin"))})
+}
@@ -4,9 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 
@@ -19,7 +19,6 @@ import (
 
 type Browser struct {
 	*rod.Browser
-	pageURLMatch *regexp.Regexp
 }
 
 func NewBrowser() (*Browser, error) {
@@ -32,10 +31,6 @@ func NewBrowser() (*Browser, error) {
 		return nil, errors.WithStack(err)
 	}
 
-	if b.pageURLMatch, err = regexp.Compile(options.PageMatch); err != nil {
-		return nil, errors.WithStack(err)
-	}
-
 	for _, p := range b.MatchedPages() {
 		if _, err := p.EvalOnNewDocument(js.Bypass); err != nil {
 			return nil, err
@@ -78,7 +73,8 @@ func NewBrowser() (*Browser, error) {
 func (b *Browser) MatchedPages() []*rod.Page {
 	pageList := make([]*rod.Page, 0)
 	for _, p := range b.MustPages() {
-		if b.pageURLMatch.MatchString(p.MustInfo().URL) {
+		u, err := url.Parse(p.MustInfo().URL)
+		if err == nil && scope.InScope(http.MethodGet, u) {
 			pageList = append(pageList, p)
 		}
 	}
@@ -93,7 +89,7 @@ func (b *Browser) ReloadPageByURL() error {
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		if b.pageURLMatch.MatchString(u.String()) {
+		if scope.InScope(http.MethodGet, u) {
 			logrus.WithField("url", u.String()).Info("reload")
 
 			// b.reloadPage(p)
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// CA is the certificate authority used to sign MITM leaf certificates
+type CA struct {
+	tls.Certificate
+	CertFile string
+	KeyFile  string
+}
+
+func NewCA() (*CA, error) {
+	certFile, keyFile := options.CACertFile, options.CAKeyFile
+	if certFile == "" && keyFile == "" {
+		certFile, keyFile = options.CACertFilename(), options.CAKeyFilename()
+		if _, err := os.Stat(certFile); os.IsNotExist(err) {
+			logrus.WithField("cert", certFile).Info("CA not found, generate new one")
+			if err = GenerateCA(certFile, keyFile); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both --ca-cert and --ca-key must be set")
+	}
+	return LoadCA(certFile, keyFile)
+}
+
+func LoadCA(certFile, keyFile string) (*CA, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !cert.Leaf.IsCA {
+		logrus.WithField("cert", certFile).Warn("certificate is not a CA, browsers will reject signed certificates")
+	}
+	return &CA{Certificate: cert, CertFile: certFile, KeyFile: keyFile}, nil
+}
+
+func GenerateCA(certFile, keyFile string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"anothergoproxy"},
+			CommonName:   fmt.Sprintf("anothergoproxy CA %s %s", hostname, now.Format("2006-01-02")),
+		},
+		NotBefore:             now.Add(-24 * time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, dir := range []string{filepath.Dir(certFile), filepath.Dir(keyFile)} {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	logrus.WithField("cert", certFile).WithField("key", keyFile).Info("CA generated")
+	return nil
+}
+
+func (ca *CA) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Leaf.Raw})
+}
+
+func (ca *CA) DER() []byte {
+	return ca.Leaf.Raw
+}
+
+func (ca *CA) Fingerprint() string {
+	return fmt.Sprintf("%x", sha1.Sum(ca.Leaf.Raw))
+}
+
+// ConnectAction returns MITM action which signs leaf certificates with ca
+func (ca *CA) ConnectAction() *goproxy.ConnectAction {
+	return &goproxy.ConnectAction{
+		Action:    goproxy.ConnectMitm,
+		TLSConfig: goproxy.TLSConfigFromCA(&ca.Certificate),
+	}
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// CertStore keeps signed leaf certificates in memory and on disk across restarts
+type CertStore struct {
+	mux   *sync.Mutex
+	certs map[string]*tls.Certificate
+	// hosts being loaded or generated, others asking for them wait
+	pending map[string]*certCall
+	path    string
+}
+
+type certCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+var _ goproxy.CertStorage = &CertStore{}
+
+func NewCertStore(ca *CA) (*CertStore, error) {
+	// leaf certificates signed by another CA are useless, so every CA gets its own dir
+	path := filepath.Join(options.CertsPath(), ca.Fingerprint()[:16])
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &CertStore{
+		mux:     &sync.Mutex{},
+		certs:   make(map[string]*tls.Certificate),
+		pending: make(map[string]*certCall),
+		path:    path,
+	}, nil
+}
+
+// Fetch generates a certificate once per host, without blocking other hosts
+func (s *CertStore) Fetch(hostname string, gen func() (*tls.Certificate, error)) (*tls.Certificate, error) {
+	s.mux.Lock()
+	if cert, ok := s.certs[hostname]; ok {
+		s.mux.Unlock()
+		return cert, nil
+	}
+	if call, ok := s.pending[hostname]; ok {
+		s.mux.Unlock()
+		<-call.done
+		return call.cert, call.err
+	}
+	call := &certCall{done: make(chan struct{})}
+	s.pending[hostname] = call
+	s.mux.Unlock()
+
+	call.cert, call.err = s.load(hostname, gen)
+
+	s.mux.Lock()
+	delete(s.pending, hostname)
+	if call.err == nil {
+		s.certs[hostname] = call.cert
+	}
+	s.mux.Unlock()
+	close(call.done)
+	return call.cert, call.err
+}
+
+// load reads the stored certificate of hostname, a missing or expired one is generated and stored
+func (s *CertStore) load(hostname string, gen func() (*tls.Certificate, error)) (*tls.Certificate, error) {
+	filename := filepath.Join(s.path, unsafeFilenameChars.ReplaceAllString(hostname, "_")+".pem")
+	if cert, err := tls.LoadX509KeyPair(filename, filename); err == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err == nil && time.Now().Before(cert.Leaf.NotAfter) {
+			return &cert, nil
+		}
+	}
+
+	cert, err := gen()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = s.write(filename, cert); err != nil {
+		logrus.WithError(err).WithField("host", hostname).Warn("store leaf certificate")
+	}
+	return cert, nil
+}
+
+func (s *CertStore) write(filename string, cert *tls.Certificate) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	data := make([]byte, 0, 4096)
+	for _, der := range cert.Certificate {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...)
+	return errors.WithStack(ioutil.WriteFile(filename, data, 0600))
+}
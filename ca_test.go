@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testCert(t *testing.T) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCertStoreFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anothergoproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := &CertStore{mux: &sync.Mutex{}, certs: make(map[string]*tls.Certificate), pending: make(map[string]*certCall), path: dir}
+
+	cert := testCert(t)
+	release := make(chan struct{})
+	var calls int32
+	slow := func() (*tls.Certificate, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return cert, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := s.Fetch("a.com", slow); err != nil || got != cert {
+				t.Errorf("a.com: %v", err)
+			}
+		}()
+	}
+
+	// another host doesn't wait for a.com
+	done := make(chan struct{})
+	go func() {
+		s.Fetch("b.com", func() (*tls.Certificate, error) { return testCert(t), nil })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("b.com waits for a.com")
+	}
+
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("a.com generated %d times", calls)
+	}
+
+	// a new store reads it from disk
+	s = &CertStore{mux: &sync.Mutex{}, certs: make(map[string]*tls.Certificate), pending: make(map[string]*certCall), path: dir}
+	got, err := s.Fetch("a.com", func() (*tls.Certificate, error) {
+		t.Errorf("stored certificate is generated again")
+		return cert, nil
+	})
+	if err != nil || string(got.Certificate[0]) != string(cert.Certificate[0]) {
+		t.Errorf("stored certificate differs: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	CacheBackendFile = "file"
+	CacheBackendBolt = "bolt"
+)
+
+func NewCache(backend string) (ReqRespCacheI, error) {
+	switch backend {
+	case CacheBackendFile, "":
+		return NewCacheFile()
+	case CacheBackendBolt:
+		return NewCacheBolt(options.CacheDBFilename())
+	}
+	return nil, errors.Errorf("unknown cache backend %q", backend)
+}
+
+// MigrateCache copies every entry keeping its hash and time
+func MigrateCache(from, to ReqRespCacheI) (int, error) {
+	entries, err := from.Find(CacheFilter{})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	n := 0
+	for _, entry := range entries {
+		req, resp, err := from.LoadEntry(entry.Hash)
+		if err != nil {
+			logrus.WithError(err).WithField("hash", entry.Hash).Warn("load cache entry")
+			continue
+		}
+		if err = to.StoreEntry(entry, req, resp); err != nil {
+			return n, errors.WithStack(err)
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltRequests       = []byte("requests")
+	boltRequestBodies  = []byte("request_bodies")
+	boltResponses      = []byte("responses")
+	boltResponseBodies = []byte("response_bodies")
+	// bodies as received of responses stored decoded
+	boltResponseEncodedBodies = []byte("response_encoded_bodies")
+	boltEntries               = []byte("entries")
+	// index buckets, keys are "<value>\x00<hash>"
+	boltIdxHost   = []byte("idx_host")
+	boltIdxPath   = []byte("idx_path")
+	boltIdxMethod = []byte("idx_method")
+	boltIdxStatus = []byte("idx_status")
+	// keys are "<big endian unix nano><hash>"
+	boltIdxTime = []byte("idx_time")
+)
+
+// CacheBolt keeps the whole cache in one bbolt file
+type CacheBolt struct {
+	db *bolt.DB
+}
+
+var _ ReqRespCacheI = &CacheBolt{}
+
+func NewCacheBolt(filename string) (*CacheBolt, error) {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", filename)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{
+			boltRequests, boltRequestBodies, boltResponses, boltResponseBodies, boltResponseEncodedBodies, boltEntries,
+			boltIdxHost, boltIdxPath, boltIdxMethod, boltIdxStatus, boltIdxTime,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.WithStack(err)
+	}
+	return &CacheBolt{db: db}, nil
+}
+
+func (c *CacheBolt) Close() error {
+	return c.db.Close()
+}
+
+func (c *CacheBolt) Load(req *RequestDTO) (*ResponseDTO, error) {
+	resp, err := c.LoadHash(req.Hash())
+	if hash := req.defaultPortHash(); err != nil && hash != "" {
+		if resp, err := c.LoadHash(hash); err == nil {
+			return resp, nil
+		}
+	}
+	return resp, err
+}
+
+func (c *CacheBolt) LoadHash(hash string) (*ResponseDTO, error) {
+	var resp *ResponseDTO
+	err := c.db.View(func(tx *bolt.Tx) error {
+		var err error
+		resp, err = boltLoadResponse(tx, []byte(hash))
+		return err
+	})
+	return resp, errors.WithStack(err)
+}
+
+func (c *CacheBolt) LoadEntry(hash string) (*RequestDTO, *ResponseDTO, error) {
+	var req *RequestDTO
+	var resp *ResponseDTO
+	err := c.db.View(func(tx *bolt.Tx) error {
+		var err error
+		if req, err = boltLoadRequest(tx, []byte(hash)); err != nil {
+			return err
+		}
+		resp, err = boltLoadResponse(tx, []byte(hash))
+		return err
+	})
+	return req, resp, errors.WithStack(err)
+}
+
+func (c *CacheBolt) Store(req *RequestDTO, resp *ResponseDTO) error {
+	return c.StoreEntry(NewCacheEntry(req.Hash(), req, resp, time.Now()), req, resp)
+}
+
+func (c *CacheBolt) StoreEntry(entry *CacheEntry, req *RequestDTO, resp *ResponseDTO) error {
+	resp = resp.Stored()
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	respData, err := json.Marshal(resp)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	entryData, err := json.Marshal(entry)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	hash := []byte(entry.Hash)
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		if old := tx.Bucket(boltEntries).Get(hash); old != nil {
+			oldEntry := &CacheEntry{}
+			if err := json.Unmarshal(old, oldEntry); err == nil {
+				if err = boltIndex(tx, oldEntry, false); err != nil {
+					return err
+				}
+			}
+		}
+		for _, kv := range []struct {
+			bucket []byte
+			value  []byte
+		}{
+			{boltRequests, reqData},
+			{boltRequestBodies, req.body},
+			{boltResponses, respData},
+			{boltResponseBodies, resp.body},
+			{boltEntries, entryData},
+		} {
+			if err := tx.Bucket(kv.bucket).Put(hash, kv.value); err != nil {
+				return err
+			}
+		}
+		if resp.encoded != nil {
+			err = tx.Bucket(boltResponseEncodedBodies).Put(hash, resp.encoded)
+		} else {
+			err = tx.Bucket(boltResponseEncodedBodies).Delete(hash)
+		}
+		if err != nil {
+			return err
+		}
+		return boltIndex(tx, entry, true)
+	})
+	return errors.WithStack(err)
+}
+
+func (c *CacheBolt) Find(filter CacheFilter) ([]*CacheEntry, error) {
+	res := make([]*CacheEntry, 0)
+	err := c.db.View(func(tx *bolt.Tx) error {
+		// walk the most selective index available
+		var bucket, prefix []byte
+		byTime := false
+		switch {
+		case filter.Host != "":
+			bucket, prefix = boltIdxHost, boltIndexKey(filter.Host, "")
+		case filter.Path != "":
+			bucket, prefix = boltIdxPath, boltIndexKey(filter.Path, "")
+		case filter.Status != 0:
+			bucket, prefix = boltIdxStatus, boltIndexKey(strconv.Itoa(filter.Status), "")
+		case filter.Method != "":
+			bucket, prefix = boltIdxMethod, boltIndexKey(filter.Method, "")
+		default:
+			bucket, byTime = boltIdxTime, true
+		}
+		entries := tx.Bucket(boltEntries)
+		cur := tx.Bucket(bucket).Cursor()
+		k, _ := cur.Seek(prefix)
+		if byTime && !filter.From.IsZero() {
+			k, _ = cur.Seek(boltTimeKey(filter.From, ""))
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+			var hash []byte
+			if byTime {
+				hash = k[8:]
+			} else {
+				hash = k[len(prefix):]
+			}
+			entry := &CacheEntry{}
+			if err := json.Unmarshal(entries.Get(hash), entry); err != nil {
+				return err
+			}
+			if byTime && !filter.To.IsZero() && !entry.Time.Before(filter.To) {
+				break
+			}
+			if filter.Match(entry) {
+				res = append(res, entry)
+			}
+		}
+		return nil
+	})
+	return res, errors.WithStack(err)
+}
+
+func (c *CacheBolt) Similar(req *RequestDTO) (map[string]*RequestDTO, error) {
+	entries, err := c.Find(CacheFilter{Host: req.URL.Host, Path: req.URL.Path, Method: req.Method})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	res := make(map[string]*RequestDTO)
+	err = c.db.View(func(tx *bolt.Tx) error {
+		for _, entry := range entries {
+			r, err := boltLoadRequest(tx, []byte(entry.Hash))
+			if err != nil {
+				return err
+			}
+			res[entry.Hash] = r
+		}
+		return nil
+	})
+	return res, errors.WithStack(err)
+}
+
+func boltLoadRequest(tx *bolt.Tx, hash []byte) (*RequestDTO, error) {
+	data := tx.Bucket(boltRequests).Get(hash)
+	if data == nil {
+		return nil, errors.Errorf("request %s not found", hash)
+	}
+	// bolt values are valid only during the transaction
+	req := &RequestDTO{body: append([]byte{}, tx.Bucket(boltRequestBodies).Get(hash)...)}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return req, nil
+}
+
+func boltLoadResponse(tx *bolt.Tx, hash []byte) (*ResponseDTO, error) {
+	data := tx.Bucket(boltResponses).Get(hash)
+	if data == nil {
+		return nil, errors.Errorf("response %s not found", hash)
+	}
+	resp, err := NewResponseDTO(nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = json.Unmarshal(data, resp); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp.body = append([]byte{}, tx.Bucket(boltResponseBodies).Get(hash)...)
+	if encoded := tx.Bucket(boltResponseEncodedBodies).Get(hash); encoded != nil {
+		resp.encoded = append([]byte{}, encoded...)
+	}
+	if err = resp.Loaded(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return resp, nil
+}
+
+func boltIndexKey(value, hash string) []byte {
+	return []byte(value + "\x00" + hash)
+}
+
+func boltTimeKey(t time.Time, hash string) []byte {
+	key := make([]byte, 8, 8+len(hash))
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return append(key, hash...)
+}
+
+func boltIndex(tx *bolt.Tx, entry *CacheEntry, add bool) error {
+	for _, kv := range []struct {
+		bucket []byte
+		key    []byte
+	}{
+		{boltIdxHost, boltIndexKey(entry.Host, entry.Hash)},
+		{boltIdxPath, boltIndexKey(entry.Path, entry.Hash)},
+		{boltIdxMethod, boltIndexKey(entry.Method, entry.Hash)},
+		{boltIdxStatus, boltIndexKey(strconv.Itoa(entry.Status), entry.Hash)},
+		{boltIdxTime, boltTimeKey(entry.Time, entry.Hash)},
+	} {
+		var err error
+		if add {
+			err = tx.Bucket(kv.bucket).Put(kv.key, []byte{})
+		} else {
+			err = tx.Bucket(kv.bucket).Delete(kv.key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,23 +4,47 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
-type CacheFile struct{}
+type CacheFile struct {
+	mux *sync.Mutex
+	// hashes by similarKey, built on first Similar call
+	index map[string][]string
+}
 
 var _ ReqRespCacheI = &CacheFile{}
 
 func NewCacheFile() (*CacheFile, error) {
-	return &CacheFile{}, nil
+	return &CacheFile{mux: &sync.Mutex{}}, nil
+}
+
+func (c *CacheFile) filename(hash, suffix string) string {
+	return filepath.Join(options.CachePath(), fmt.Sprintf("%s_%s", hash, suffix))
 }
 
 func (c *CacheFile) Load(req *RequestDTO) (*ResponseDTO, error) {
-	hash := req.Hash()
-	ResponseInfoFilename := filepath.Join(options.CachePath(), fmt.Sprintf("%s_resp.json", hash))
-	ResponseBodyFilename := filepath.Join(options.CachePath(), fmt.Sprintf("%s_resp_body", hash))
+	resp, err := c.LoadHash(req.Hash())
+	if hash := req.defaultPortHash(); hash != "" && os.IsNotExist(errors.Cause(err)) {
+		resp, err = c.LoadHash(hash)
+	}
+	if os.IsNotExist(errors.Cause(err)) {
+		return c.LoadHash(req.legacyHash())
+	}
+	return resp, err
+}
+
+func (c *CacheFile) LoadHash(hash string) (*ResponseDTO, error) {
+	ResponseInfoFilename := c.filename(hash, "resp.json")
+	ResponseBodyFilename := c.filename(hash, "resp_body")
+	ResponseEncodedBodyFilename := c.filename(hash, "resp_body_encoded")
 
 	data, err := ioutil.ReadFile(ResponseInfoFilename)
 	if err != nil {
@@ -41,17 +65,38 @@ func (c *CacheFile) Load(req *RequestDTO) (*ResponseDTO, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	// logrus.Info("dlkjfdlkfj")
+	if r.decodedFrom != "" {
+		r.encoded, err = ioutil.ReadFile(ResponseEncodedBodyFilename)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.WithStack(err)
+		}
+	}
+	if err = r.Loaded(); err != nil {
+		return nil, errors.WithStack(err)
+	}
 
 	return r, nil
 }
 
 func (c *CacheFile) Store(req *RequestDTO, resp *ResponseDTO) error {
-	hash := req.Hash()
-	RequestInfoFilename := filepath.Join(options.CachePath(), fmt.Sprintf("%s_req.json", hash))
-	RequestBodyFilename := filepath.Join(options.CachePath(), fmt.Sprintf("%s_req_body", hash))
-	ResponseInfoFilename := filepath.Join(options.CachePath(), fmt.Sprintf("%s_resp.json", hash))
-	ResponseBodyFilename := filepath.Join(options.CachePath(), fmt.Sprintf("%s_resp_body", hash))
+	return c.StoreEntry(NewCacheEntry(req.Hash(), req, resp, time.Now()), req, resp)
+}
+
+func (c *CacheFile) StoreEntry(entry *CacheEntry, req *RequestDTO, resp *ResponseDTO) error {
+	resp = resp.Stored()
+	hash := entry.Hash
+	c.mux.Lock()
+	if c.index != nil {
+		key := similarKey(req)
+		c.index[key] = append(c.index[key], hash)
+	}
+	c.mux.Unlock()
+
+	RequestInfoFilename := c.filename(hash, "req.json")
+	RequestBodyFilename := c.filename(hash, "req_body")
+	ResponseInfoFilename := c.filename(hash, "resp.json")
+	ResponseBodyFilename := c.filename(hash, "resp_body")
+	ResponseEncodedBodyFilename := c.filename(hash, "resp_body_encoded")
 
 	b, err := json.MarshalIndent(req, "", "  ")
 	if err != nil {
@@ -82,5 +127,118 @@ func (c *CacheFile) Store(req *RequestDTO, resp *ResponseDTO) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	if resp.encoded != nil {
+		err = ioutil.WriteFile(ResponseEncodedBodyFilename, resp.encoded, 0644)
+	} else if err = os.Remove(ResponseEncodedBodyFilename); os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !entry.Time.IsZero() {
+		// the file time is the entry time
+		if err = os.Chtimes(ResponseInfoFilename, entry.Time, entry.Time); err != nil {
+			return errors.WithStack(err)
+		}
+	}
 	return nil
 }
+
+func (c *CacheFile) LoadEntry(hash string) (*RequestDTO, *ResponseDTO, error) {
+	req, err := c.loadRequest(hash)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	resp, err := c.LoadHash(hash)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return req, resp, nil
+}
+
+// Find reads every stored request, it is slow on big caches
+func (c *CacheFile) Find(filter CacheFilter) ([]*CacheEntry, error) {
+	filenames, err := filepath.Glob(filepath.Join(options.CachePath(), "*_resp.json"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	res := make([]*CacheEntry, 0)
+	for _, filename := range filenames {
+		hash := strings.TrimSuffix(filepath.Base(filename), "_resp.json")
+		fi, err := os.Stat(filename)
+		if err != nil {
+			continue
+		}
+		if !filter.From.IsZero() && fi.ModTime().Before(filter.From) || !filter.To.IsZero() && !fi.ModTime().Before(filter.To) {
+			continue
+		}
+		req, resp, err := c.LoadEntry(hash)
+		if err != nil {
+			logrus.WithError(err).WithField("hash", hash).Warn("load cache entry")
+			continue
+		}
+		if entry := NewCacheEntry(hash, req, resp, fi.ModTime()); filter.Match(entry) {
+			res = append(res, entry)
+		}
+	}
+	return res, nil
+}
+
+func (c *CacheFile) Similar(req *RequestDTO) (map[string]*RequestDTO, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.index == nil {
+		if err := c.buildIndex(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	res := make(map[string]*RequestDTO)
+	for _, hash := range c.index[similarKey(req)] {
+		r, err := c.loadRequest(hash)
+		if err != nil {
+			logrus.WithError(err).WithField("hash", hash).Warn("load cached request")
+			continue
+		}
+		res[hash] = r
+	}
+	return res, nil
+}
+
+func (c *CacheFile) Close() error {
+	return nil
+}
+
+func (c *CacheFile) buildIndex() error {
+	filenames, err := filepath.Glob(filepath.Join(options.CachePath(), "*_req.json"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	c.index = make(map[string][]string)
+	for _, filename := range filenames {
+		hash := strings.TrimSuffix(filepath.Base(filename), "_req.json")
+		r, err := c.loadRequest(hash)
+		if err != nil {
+			logrus.WithError(err).WithField("hash", hash).Warn("load cached request")
+			continue
+		}
+		key := similarKey(r)
+		c.index[key] = append(c.index[key], hash)
+	}
+	return nil
+}
+
+func (c *CacheFile) loadRequest(hash string) (*RequestDTO, error) {
+	data, err := ioutil.ReadFile(c.filename(hash, "req.json"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	body, err := ioutil.ReadFile(c.filename(hash, "req_body"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req := &RequestDTO{body: body}
+	if err = json.Unmarshal(data, req); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return req, nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// cacheKeyRules are used by RequestDTO.Hash
+var cacheKeyRules = &CacheKeyRules{}
+
+// CacheKeyFields selects fields of one kind for the cache key.
+// Empty Include means the default: all query, form and json fields, no headers and cookies.
+// "*" in Include means all of them. Ignore wins over Include.
+type CacheKeyFields struct {
+	Include []string `json:"include"`
+	Ignore  []string `json:"ignore"`
+}
+
+func (f CacheKeyFields) Keep(name string, byDefault bool, equal func(a, b string) bool) bool {
+	for _, s := range f.Ignore {
+		if equal(s, name) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return byDefault
+	}
+	for _, s := range f.Include {
+		if s == "*" || equal(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+type CacheKeyRule struct {
+	// url regexp, empty matches everything
+	Match  string         `json:"match"`
+	Query  CacheKeyFields `json:"query"`
+	Form   CacheKeyFields `json:"form"`
+	Header CacheKeyFields `json:"header"`
+	Cookie CacheKeyFields `json:"cookie"`
+	// dot separated paths, "*" matches any key or array index (example: "items.*.nonce")
+	JSON      CacheKeyFields `json:"json"`
+	SortQuery bool           `json:"sort_query"`
+	SortForm  bool           `json:"sort_form"`
+
+	re *regexp.Regexp
+}
+
+type CacheKeyRules struct {
+	Rules []*CacheKeyRule `json:"rules"`
+}
+
+func LoadCacheKeyRules(filename string) (*CacheKeyRules, error) {
+	rules := &CacheKeyRules{}
+	if filename == "" {
+		return rules, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = json.Unmarshal(data, rules); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", filename)
+	}
+	for _, rule := range rules.Rules {
+		if rule.re, err = regexp.Compile(rule.Match); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *CacheKeyRules) rule(req *RequestDTO) *CacheKeyRule {
+	u := req.URL.String()
+	for _, rule := range r.Rules {
+		if rule.re == nil || rule.re.MatchString(u) {
+			return rule
+		}
+	}
+	return &CacheKeyRule{}
+}
+
+// Key is what the request hash is calculated from
+func (r *CacheKeyRules) Key(req *RequestDTO) string {
+	rule := r.rule(req)
+	b := &bytes.Buffer{}
+
+	u := *req.URL
+	u.Fragment = ""
+	u.RawQuery = filterPairs(u.RawQuery, rule.Query, rule.SortQuery)
+	fmt.Fprintf(b, "%s %s\n", req.Method, u.String())
+
+	headerNames := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		if name != "Cookie" && rule.Header.Keep(name, false, strings.EqualFold) {
+			headerNames = append(headerNames, name)
+		}
+	}
+	sort.Strings(headerNames)
+	for _, name := range headerNames {
+		fmt.Fprintf(b, "%s: %s\n", name, strings.Join(req.Header[name], ", "))
+	}
+	for _, c := range req.Cookies() {
+		if rule.Cookie.Keep(c.Name, false, stringsEqual) {
+			fmt.Fprintf(b, "Cookie %s=%s\n", c.Name, c.Value)
+		}
+	}
+	b.WriteString("\n")
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		b.WriteString(filterPairs(string(req.body), rule.Form, rule.SortForm))
+	case strings.HasSuffix(mediaType, "json"):
+		b.Write(canonicalJSON(req.body, rule.JSON))
+	default:
+		b.Write(req.body)
+	}
+	return b.String()
+}
+
+func (r *CacheKeyRules) Hash(req *RequestDTO) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(r.Key(req))))
+}
+
+func stringsEqual(a, b string) bool {
+	return a == b
+}
+
+// filterPairs filters and sorts a=1&b=2 keeping the original encoding
+func filterPairs(raw string, fields CacheKeyFields, sorted bool) string {
+	if raw == "" {
+		return raw
+	}
+	pairs := make([]string, 0)
+	for _, pair := range strings.Split(raw, "&") {
+		name := strings.SplitN(pair, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if fields.Keep(name, true, stringsEqual) {
+			pairs = append(pairs, pair)
+		}
+	}
+	if sorted {
+		sort.Strings(pairs)
+	}
+	return strings.Join(pairs, "&")
+}
+
+// canonicalJSON returns body with sorted keys and without ignored paths,
+// or only "path=value" lines of included paths
+func canonicalJSON(body []byte, fields CacheKeyFields) []byte {
+	doc, err := decodeJSON(body)
+	if err != nil {
+		return body
+	}
+	for _, path := range fields.Ignore {
+		doc = jsonDelete(doc, strings.Split(path, "."))
+	}
+	if len(fields.Include) > 0 {
+		b := &bytes.Buffer{}
+		for _, path := range fields.Include {
+			for _, v := range jsonGet(doc, strings.Split(path, ".")) {
+				data, _ := json.Marshal(v)
+				fmt.Fprintf(b, "%s=%s\n", path, data)
+			}
+		}
+		return b.Bytes()
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return data
+}
+
+// decodeJSON keeps numbers as they are written, float64 would make big ids equal
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("data after json value")
+	}
+	return doc, nil
+}
+
+func jsonGet(doc interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{doc}
+	}
+	res := make([]interface{}, 0)
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			if path[0] == "*" || path[0] == k {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			res = append(res, jsonGet(v[k], path[1:])...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			if path[0] == "*" || path[0] == strconv.Itoa(i) {
+				res = append(res, jsonGet(item, path[1:])...)
+			}
+		}
+	}
+	return res
+}
+
+func jsonDelete(doc interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return doc
+	}
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for k := range v {
+			if path[0] != "*" && path[0] != k {
+				continue
+			}
+			if len(path) == 1 {
+				delete(v, k)
+			} else {
+				v[k] = jsonDelete(v[k], path[1:])
+			}
+		}
+	case []interface{}:
+		res := make([]interface{}, 0, len(v))
+		for i, item := range v {
+			if path[0] != "*" && path[0] != strconv.Itoa(i) {
+				res = append(res, item)
+			} else if len(path) > 1 {
+				res = append(res, jsonDelete(item, path[1:]))
+			}
+		}
+		return res
+	}
+	return doc
+}
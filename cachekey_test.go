@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func newTestRequest(method, url, contentType, body string, header ...string) *RequestDTO {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Add(header[i], header[i+1])
+	}
+	return NewRequestDTO(req)
+}
+
+func TestCacheKeyRules(t *testing.T) {
+	const form = "application/x-www-form-urlencoded"
+	const js = "application/json"
+
+	tests := []struct {
+		name string
+		rule *CacheKeyRule
+		a, b *RequestDTO
+		same bool
+	}{
+		{
+			name: "method matters",
+			a:    newTestRequest("GET", "http://example.com/a", "", ""),
+			b:    newTestRequest("HEAD", "http://example.com/a", "", ""),
+		},
+		{
+			name: "query order matters by default",
+			a:    newTestRequest("GET", "http://example.com/?a=1&b=2", "", ""),
+			b:    newTestRequest("GET", "http://example.com/?b=2&a=1", "", ""),
+		},
+		{
+			name: "sorted query",
+			rule: &CacheKeyRule{SortQuery: true},
+			a:    newTestRequest("GET", "http://example.com/?a=1&b=2", "", ""),
+			b:    newTestRequest("GET", "http://example.com/?b=2&a=1", "", ""),
+			same: true,
+		},
+		{
+			name: "ignored query param",
+			rule: &CacheKeyRule{Query: CacheKeyFields{Ignore: []string{"_"}}},
+			a:    newTestRequest("GET", "http://example.com/?a=1&_=123", "", ""),
+			b:    newTestRequest("GET", "http://example.com/?a=1&_=456", "", ""),
+			same: true,
+		},
+		{
+			name: "escaped ignored query param",
+			rule: &CacheKeyRule{Query: CacheKeyFields{Ignore: []string{"a b"}}},
+			a:    newTestRequest("GET", "http://example.com/?a%20b=1", "", ""),
+			b:    newTestRequest("GET", "http://example.com/?a+b=2", "", ""),
+			same: true,
+		},
+		{
+			name: "included query params only",
+			rule: &CacheKeyRule{Query: CacheKeyFields{Include: []string{"id"}}},
+			a:    newTestRequest("GET", "http://example.com/?id=1&t=1", "", ""),
+			b:    newTestRequest("GET", "http://example.com/?t=2&id=1", "", ""),
+			same: true,
+		},
+		{
+			name: "sorted form without ignored field",
+			rule: &CacheKeyRule{SortForm: true, Form: CacheKeyFields{Ignore: []string{"csrf"}}},
+			a:    newTestRequest("POST", "http://example.com/", form, "b=2&csrf=x&a=1"),
+			b:    newTestRequest("POST", "http://example.com/", form, "a=1&b=2&csrf=y"),
+			same: true,
+		},
+		{
+			name: "headers are ignored by default",
+			a:    newTestRequest("GET", "http://example.com/", "", "", "X-Token", "1"),
+			b:    newTestRequest("GET", "http://example.com/", "", "", "X-Token", "2"),
+			same: true,
+		},
+		{
+			name: "included header",
+			rule: &CacheKeyRule{Header: CacheKeyFields{Include: []string{"x-token"}}},
+			a:    newTestRequest("GET", "http://example.com/", "", "", "X-Token", "1"),
+			b:    newTestRequest("GET", "http://example.com/", "", "", "X-Token", "2"),
+		},
+		{
+			name: "included cookie",
+			rule: &CacheKeyRule{Cookie: CacheKeyFields{Include: []string{"session"}}},
+			a:    newTestRequest("GET", "http://example.com/", "", "", "Cookie", "session=1; ga=1"),
+			b:    newTestRequest("GET", "http://example.com/", "", "", "Cookie", "ga=2; session=1"),
+			same: true,
+		},
+		{
+			name: "json key order",
+			a:    newTestRequest("POST", "http://example.com/", js, `{"a":1,"b":[1,2]}`),
+			b:    newTestRequest("POST", "http://example.com/", js, `{ "b": [1, 2], "a": 1 }`),
+			same: true,
+		},
+		{
+			name: "json ignored path",
+			rule: &CacheKeyRule{JSON: CacheKeyFields{Ignore: []string{"items.*.nonce"}}},
+			a:    newTestRequest("POST", "http://example.com/", js, `{"items":[{"id":1,"nonce":"a"}]}`),
+			b:    newTestRequest("POST", "http://example.com/", js, `{"items":[{"id":1,"nonce":"b"}]}`),
+			same: true,
+		},
+		{
+			name: "json included path",
+			rule: &CacheKeyRule{JSON: CacheKeyFields{Include: []string{"query"}}},
+			a:    newTestRequest("POST", "http://example.com/", js, `{"query":"q","ts":1}`),
+			b:    newTestRequest("POST", "http://example.com/", js, `{"ts":2,"query":"q"}`),
+			same: true,
+		},
+		{
+			name: "json ids above 2^53",
+			a:    newTestRequest("POST", "http://example.com/", js, `{"id":9007199254740993}`),
+			b:    newTestRequest("POST", "http://example.com/", js, `{"id":9007199254740992}`),
+		},
+		{
+			name: "json included ids above 2^53",
+			rule: &CacheKeyRule{JSON: CacheKeyFields{Include: []string{"id"}}},
+			a:    newTestRequest("POST", "http://example.com/", js, `{"id":9007199254740993}`),
+			b:    newTestRequest("POST", "http://example.com/", js, `{"id":9007199254740992}`),
+		},
+		{
+			name: "invalid json is kept as is",
+			a:    newTestRequest("POST", "http://example.com/", js, `{"a":1} {"b":2}`),
+			b:    newTestRequest("POST", "http://example.com/", js, `{"a":1}`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := &CacheKeyRules{}
+			if tt.rule != nil {
+				tt.rule.re = regexp.MustCompile(tt.rule.Match)
+				rules.Rules = []*CacheKeyRule{tt.rule}
+			}
+			a, b := rules.Key(tt.a), rules.Key(tt.b)
+			if (a == b) != tt.same {
+				t.Errorf("same key %v, want %v\n%s\n--\n%s", a == b, tt.same, a, b)
+			}
+			if (rules.Hash(tt.a) == rules.Hash(tt.b)) != tt.same {
+				t.Errorf("hashes don't follow keys")
+			}
+		})
+	}
+}
+
+func TestCacheKeyRuleMatch(t *testing.T) {
+	rules := &CacheKeyRules{Rules: []*CacheKeyRule{
+		{Match: `/api/`, SortQuery: true},
+		{Match: ``},
+	}}
+	for _, rule := range rules.Rules {
+		rule.re = regexp.MustCompile(rule.Match)
+	}
+	a := newTestRequest("GET", "http://example.com/api/?b=1&a=1", "", "")
+	b := newTestRequest("GET", "http://example.com/api/?a=1&b=1", "", "")
+	if rules.Key(a) != rules.Key(b) {
+		t.Errorf("first matching rule is not used")
+	}
+	a = newTestRequest("GET", "http://example.com/?b=1&a=1", "", "")
+	b = newTestRequest("GET", "http://example.com/?a=1&b=1", "", "")
+	if rules.Key(a) == rules.Key(b) {
+		t.Errorf("rule applied to a url it doesn't match")
+	}
+}
+
+func TestParamsJSONNumbers(t *testing.T) {
+	req := newTestRequest(http.MethodPost, "http://example.com/", "application/json", `{"id":9007199254740993,"f":1.5,"s":"x","n":null}`)
+	got := map[string]string{}
+	for _, p := range req.Params() {
+		if p.Kind == ParamJSON {
+			got[p.Name] = p.Value
+		}
+	}
+	want := map[string]string{"id": "9007199254740993", "f": "1.5", "s": "x", "n": "null"}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%s = %q, want %q", name, got[name], value)
+		}
+	}
+}
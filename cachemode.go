@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type CacheMode string
+
+const (
+	// fetch everything, store responses
+	CacheModeRecord CacheMode = "record"
+	// serve cached response if any, store fetched ones
+	CacheModeReplay CacheMode = "replay"
+	// serve cached responses only, 504 on miss
+	CacheModeOffline CacheMode = "offline"
+	// don't touch the cache at all
+	CacheModePassthrough CacheMode = "passthrough"
+)
+
+func (m CacheMode) Valid() bool {
+	switch m {
+	case CacheModeRecord, CacheModeReplay, CacheModeOffline, CacheModePassthrough:
+		return true
+	}
+	return false
+}
+
+func (m CacheMode) Replay() bool {
+	return m == CacheModeReplay || m == CacheModeOffline
+}
+
+func (m CacheMode) Record() bool {
+	return m == CacheModeRecord || m == CacheModeReplay
+}
+
+type CacheModeRule struct {
+	Match string    `json:"match"`
+	Mode  CacheMode `json:"mode"`
+
+	re *regexp.Regexp
+}
+
+// CacheModes picks the mode of the first rule matching the url
+type CacheModes struct {
+	mux     *sync.RWMutex
+	Default CacheMode        `json:"default"`
+	Rules   []*CacheModeRule `json:"rules"`
+	// url regexps where replay falls back to the closest recorded request
+	Fuzzy []string `json:"fuzzy"`
+
+	fuzzy []*regexp.Regexp
+}
+
+// NewCacheModes parses specs like "offline" (default mode) or "offline:^https://example\.com/"
+func NewCacheModes(specs []string, fuzzy []string) (*CacheModes, error) {
+	m := &CacheModes{mux: &sync.RWMutex{}}
+	def := CacheModeReplay
+	rules := make([]*CacheModeRule, 0, len(specs))
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) == 1 {
+			def = CacheMode(parts[0])
+			continue
+		}
+		rules = append(rules, &CacheModeRule{Mode: CacheMode(parts[0]), Match: parts[1]})
+	}
+	if err := m.Set(def, rules, fuzzy); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return m, nil
+}
+
+func (m *CacheModes) Set(def CacheMode, rules []*CacheModeRule, fuzzy []string) error {
+	if !def.Valid() {
+		return errors.Errorf("unknown cache mode %q", def)
+	}
+	for _, rule := range rules {
+		if !rule.Mode.Valid() {
+			return errors.Errorf("unknown cache mode %q", rule.Mode)
+		}
+		var err error
+		if rule.re, err = regexp.Compile(rule.Match); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	fuzzyRe := make([]*regexp.Regexp, 0, len(fuzzy))
+	for _, s := range fuzzy {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		fuzzyRe = append(fuzzyRe, re)
+	}
+	m.mux.Lock()
+	m.Default, m.Rules = def, rules
+	m.Fuzzy, m.fuzzy = fuzzy, fuzzyRe
+	m.mux.Unlock()
+	return nil
+}
+
+func (m *CacheModes) Mode(u *url.URL) CacheMode {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	s := u.String()
+	for _, rule := range m.Rules {
+		if rule.re.MatchString(s) {
+			return rule.Mode
+		}
+	}
+	return m.Default
+}
+
+func (m *CacheModes) FuzzyMatch(u *url.URL) bool {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	s := u.String()
+	for _, re := range m.fuzzy {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *CacheModes) Snapshot() CacheModes {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return CacheModes{Default: m.Default, Rules: m.Rules, Fuzzy: m.Fuzzy}
+}
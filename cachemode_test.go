@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCacheModesMITMURL(t *testing.T) {
+	m, err := NewCacheModes([]string{"replay", `offline:^https://api\.example\.com/`}, []string{`^https://api\.example\.com/search`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url   string
+		mode  CacheMode
+		fuzzy bool
+	}{
+		{"https://api.example.com:443/v1/me", CacheModeOffline, false},
+		{"https://API.example.com:443/search?q=1", CacheModeOffline, true},
+		{"https://api.example.com:8443/v1/me", CacheModeReplay, false},
+		{"https://www.example.com:443/", CacheModeReplay, false},
+	}
+	for _, tt := range tests {
+		u := mitmRequest(http.MethodGet, tt.url).URL
+		if mode := m.Mode(u); mode != tt.mode {
+			t.Errorf("%s mode %s, want %s", tt.url, mode, tt.mode)
+		}
+		if fuzzy := m.FuzzyMatch(u); fuzzy != tt.fuzzy {
+			t.Errorf("%s fuzzy %v, want %v", tt.url, fuzzy, tt.fuzzy)
+		}
+	}
+}
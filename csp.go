@@ -0,0 +1,379 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hosts serving JSONP endpoints or libraries with known CSP bypass gadgets (angular and the like)
+var cspBypassHosts = []string{
+	"www.google.com", "google.com", "accounts.google.com", "apis.google.com",
+	"ajax.googleapis.com", "www.googleapis.com", "maps.googleapis.com", "www.googletagmanager.com",
+	"www.youtube.com", "graph.facebook.com", "api.twitter.com",
+	"cdnjs.cloudflare.com", "cdn.jsdelivr.net", "unpkg.com",
+	"api.vk.com", "api-maps.yandex.ru", "yandex.st", "mc.yandex.ru",
+}
+
+// directives which fall back to default-src
+var cspFetchDirectives = map[string]bool{
+	"child-src": true, "connect-src": true, "font-src": true, "frame-src": true, "img-src": true,
+	"manifest-src": true, "media-src": true, "object-src": true, "prefetch-src": true,
+	"script-src": true, "style-src": true, "worker-src": true,
+}
+
+type CSPPolicy struct {
+	Raw        string              `json:"raw"`
+	ReportOnly bool                `json:"report_only"`
+	Directives map[string][]string `json:"directives"`
+}
+
+type CSPIssue struct {
+	Severity  string `json:"severity"`
+	Directive string `json:"directive,omitempty"`
+	Title     string `json:"title"`
+	Value     string `json:"value,omitempty"`
+}
+
+// ParseCSP keeps the first of repeated directives like browsers do
+func ParseCSP(raw string, reportOnly bool) *CSPPolicy {
+	p := &CSPPolicy{Raw: raw, ReportOnly: reportOnly, Directives: make(map[string][]string)}
+	for _, directive := range strings.Split(raw, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if _, ok := p.Directives[name]; ok {
+			continue
+		}
+		sources := make([]string, 0, len(fields)-1)
+		for _, src := range fields[1:] {
+			// keywords are case insensitive, nonces and hashes are not
+			if strings.HasPrefix(src, "'") && !cspIsNonceOrHash(src) {
+				src = strings.ToLower(src)
+			}
+			sources = append(sources, src)
+		}
+		p.Directives[name] = sources
+	}
+	return p
+}
+
+// Effective returns the sources of a directive or of default-src it falls back to
+func (p *CSPPolicy) Effective(name string) (string, []string, bool) {
+	if sources, ok := p.Directives[name]; ok {
+		return name, sources, true
+	}
+	if cspFetchDirectives[name] {
+		if sources, ok := p.Directives["default-src"]; ok {
+			return "default-src", sources, true
+		}
+	}
+	return name, nil, false
+}
+
+func (p *CSPPolicy) Nonces() []string {
+	res := make([]string, 0)
+	for _, sources := range p.Directives {
+		for _, src := range sources {
+			if strings.HasPrefix(src, "'nonce-") {
+				res = append(res, strings.TrimSuffix(strings.TrimPrefix(src, "'nonce-"), "'"))
+			}
+		}
+	}
+	return res
+}
+
+func (p *CSPPolicy) Evaluate() []*CSPIssue {
+	res := make([]*CSPIssue, 0)
+	add := func(severity, directive, title, value string) {
+		res = append(res, &CSPIssue{Severity: severity, Directive: directive, Title: title, Value: value})
+	}
+
+	nonceOrHash, strictDynamic := false, false
+	name, script, ok := p.Effective("script-src")
+	if !ok {
+		add(SeverityHigh, "script-src", "missing script-src and default-src", "")
+	}
+	for _, src := range script {
+		nonceOrHash = nonceOrHash || cspIsNonceOrHash(src)
+		strictDynamic = strictDynamic || src == "'strict-dynamic'"
+	}
+	for _, src := range script {
+		switch {
+		case src == "'unsafe-inline'" && !nonceOrHash:
+			add(SeverityHigh, name, "script-src allows 'unsafe-inline'", src)
+		case src == "'unsafe-eval'":
+			add(SeverityMedium, name, "script-src allows 'unsafe-eval'", src)
+		case strictDynamic:
+			// host sources and schemes are ignored
+		case cspIsWildcard(src):
+			add(SeverityHigh, name, "script-src allows any host", src)
+		case strings.HasPrefix(src, "http://"):
+			add(SeverityLow, name, "script-src allows plain http host", src)
+		case cspIsBypassHost(src):
+			add(SeverityHigh, name, "script-src allows host with JSONP endpoints or script gadgets", src)
+		case strings.Contains(src, "*."):
+			add(SeverityLow, name, "script-src allows wildcard subdomains", src)
+		}
+	}
+
+	name, object, ok := p.Effective("object-src")
+	if !ok {
+		add(SeverityMedium, "object-src", "missing object-src", "")
+	}
+	for _, src := range object {
+		if cspIsWildcard(src) {
+			add(SeverityMedium, name, "object-src allows any host", src)
+		}
+	}
+
+	if _, ok := p.Directives["base-uri"]; !ok {
+		severity := SeverityLow
+		if nonceOrHash || strictDynamic {
+			// <base> rewrites relative script urls, nonces do not help
+			severity = SeverityMedium
+		}
+		add(severity, "base-uri", "missing base-uri", "")
+	}
+
+	names := make([]string, 0, len(p.Directives))
+	for name := range p.Directives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "script-src" || name == "object-src" {
+			continue
+		}
+		if _, ok := p.Directives["script-src"]; !ok && name == "default-src" {
+			// already reported as script-src
+			continue
+		}
+		for _, src := range p.Directives[name] {
+			if src == "*" {
+				add(SeverityLow, name, name+" allows any host", src)
+			}
+		}
+	}
+	return res
+}
+
+func cspIsNonceOrHash(src string) bool {
+	src = strings.ToLower(src)
+	return strings.HasPrefix(src, "'nonce-") || strings.HasPrefix(src, "'sha256-") ||
+		strings.HasPrefix(src, "'sha384-") || strings.HasPrefix(src, "'sha512-")
+}
+
+func cspIsWildcard(src string) bool {
+	switch strings.ToLower(src) {
+	case "*", "http:", "https:", "data:", "http://*", "https://*":
+		return true
+	}
+	return false
+}
+
+func cspSourceHost(src string) string {
+	src = strings.ToLower(src)
+	if i := strings.Index(src, "://"); i >= 0 {
+		src = src[i+3:]
+	}
+	if i := strings.IndexAny(src, "/"); i >= 0 {
+		src = src[:i]
+	}
+	if i := strings.LastIndexByte(src, ':'); i >= 0 {
+		src = src[:i]
+	}
+	return src
+}
+
+func cspIsBypassHost(src string) bool {
+	if strings.HasPrefix(src, "'") {
+		return false
+	}
+	host := cspSourceHost(src)
+	for _, bypass := range cspBypassHosts {
+		if host == bypass || strings.HasPrefix(host, "*.") && strings.HasSuffix(bypass, host[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// CSPPolicyReport is a policy as first seen, policies differing only in nonces count as one
+type CSPPolicyReport struct {
+	*CSPPolicy
+	Issues    []*CSPIssue `json:"issues"`
+	HistoryID uint64      `json:"history_id"`
+	FirstSeen time.Time   `json:"first_seen"`
+	LastSeen  time.Time   `json:"last_seen"`
+	Count     int         `json:"count"`
+
+	key string
+}
+
+var cspNonceRe = regexp.MustCompile(`(?i)'nonce-[^']*'`)
+
+// cspPolicyKey is the raw policy without nonce values
+func cspPolicyKey(p *CSPPolicy) string {
+	return cspNonceRe.ReplaceAllString(p.Raw, "'nonce'")
+}
+
+// nonces remembered per origin to find reused ones, the oldest are forgotten first
+const cspMaxNonces = 1000
+
+// CSPOrigin is every distinct policy an origin sent
+type CSPOrigin struct {
+	Origin   string             `json:"origin"`
+	Policies []*CSPPolicyReport `json:"policies"`
+	// issues spanning responses like nonce reuse
+	Issues []*CSPIssue `json:"issues"`
+
+	// history id of the response a nonce came with, nonceOrder is oldest first
+	nonces     map[string]uint64
+	nonceOrder []string
+}
+
+// CSPTracker evaluates the original CSP headers per origin, it is a passive check
+type CSPTracker struct {
+	mux     *sync.Mutex
+	origins map[string]*CSPOrigin
+}
+
+func NewCSPTracker() *CSPTracker {
+	return &CSPTracker{mux: &sync.Mutex{}, origins: make(map[string]*CSPOrigin)}
+}
+
+func (t *CSPTracker) Name() string {
+	return "csp"
+}
+
+func (t *CSPTracker) Check(req *RequestDTO, resp *ResponseDTO) []*Finding {
+	policies := make([]*CSPPolicy, 0)
+	for _, raw := range resp.Header.Values("Content-Security-Policy") {
+		policies = append(policies, ParseCSP(raw, false))
+	}
+	for _, raw := range resp.Header.Values("Content-Security-Policy-Report-Only") {
+		policies = append(policies, ParseCSP(raw, true))
+	}
+	if len(policies) == 0 {
+		return nil
+	}
+	var historyID uint64
+	if req.history != nil {
+		historyID = req.history.ID
+	}
+
+	res := make([]*Finding, 0)
+	report := func(issue *CSPIssue, reportOnly bool) {
+		f := &Finding{Severity: issue.Severity, Title: issue.Title, Context: "enforced", Evidence: issue.Value}
+		if reportOnly {
+			f.Context = "report-only"
+		}
+		if issue.Directive != "" {
+			f.Evidence = strings.TrimSpace(issue.Directive + " " + issue.Value)
+		}
+		res = append(res, f)
+	}
+	enforced := false
+	for _, p := range policies {
+		for _, issue := range t.observe(cspOrigin(req), p, historyID) {
+			report(issue, p.ReportOnly)
+		}
+		enforced = enforced || !p.ReportOnly
+	}
+	if !enforced {
+		report(&CSPIssue{Severity: SeverityMedium, Title: "CSP is only report-only"}, true)
+	}
+	return res
+}
+
+func cspOrigin(req *RequestDTO) string {
+	return req.URL.Scheme + "://" + req.URL.Host
+}
+
+// observe returns the issues of p and the nonces reused from other responses
+func (t *CSPTracker) observe(origin string, p *CSPPolicy, historyID uint64) []*CSPIssue {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	o, ok := t.origins[origin]
+	if !ok {
+		o = &CSPOrigin{Origin: origin, Policies: make([]*CSPPolicyReport, 0), Issues: make([]*CSPIssue, 0), nonces: make(map[string]uint64)}
+		t.origins[origin] = o
+	}
+	now := time.Now()
+	key := cspPolicyKey(p)
+	var r *CSPPolicyReport
+	for _, seen := range o.Policies {
+		if seen.key == key && seen.ReportOnly == p.ReportOnly {
+			r = seen
+			break
+		}
+	}
+	if r == nil {
+		r = &CSPPolicyReport{CSPPolicy: p, Issues: p.Evaluate(), HistoryID: historyID, FirstSeen: now, key: key}
+		o.Policies = append(o.Policies, r)
+	}
+	r.LastSeen = now
+	r.Count++
+
+	issues := append([]*CSPIssue{}, r.Issues...)
+	for _, nonce := range p.Nonces() {
+		first, ok := o.nonces[nonce]
+		if !ok {
+			o.addNonce(nonce, historyID)
+			continue
+		}
+		if first == historyID {
+			// both headers of one response
+			continue
+		}
+		issue := &CSPIssue{Severity: SeverityHigh, Directive: "script-src", Title: "nonce reused across responses", Value: "'nonce-" + nonce + "'"}
+		if !o.hasIssue(issue) {
+			o.Issues = append(o.Issues, issue)
+		}
+		issues = append(issues, issue)
+	}
+	return issues
+}
+
+func (o *CSPOrigin) addNonce(nonce string, historyID uint64) {
+	o.nonces[nonce] = historyID
+	o.nonceOrder = append(o.nonceOrder, nonce)
+	if len(o.nonceOrder) > cspMaxNonces {
+		delete(o.nonces, o.nonceOrder[0])
+		o.nonceOrder = o.nonceOrder[1:]
+	}
+}
+
+func (o *CSPOrigin) hasIssue(issue *CSPIssue) bool {
+	for _, seen := range o.Issues {
+		if *seen == *issue {
+			return true
+		}
+	}
+	return false
+}
+
+// Origins returns copies sorted by origin, empty origin is all of them
+func (t *CSPTracker) Origins(origin string) []*CSPOrigin {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	res := make([]*CSPOrigin, 0, len(t.origins))
+	for name, o := range t.origins {
+		if origin != "" && !strings.EqualFold(origin, name) {
+			continue
+		}
+		c := &CSPOrigin{Origin: o.Origin, Issues: append([]*CSPIssue{}, o.Issues...)}
+		for _, r := range o.Policies {
+			copied := *r
+			c.Policies = append(c.Policies, &copied)
+		}
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Origin < res[j].Origin })
+	return res
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func cspIssueTitles(issues []*CSPIssue) []string {
+	res := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		res = append(res, issue.Severity+" "+issue.Title)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestParseCSP(t *testing.T) {
+	p := ParseCSP("Script-Src 'SELF' 'nonce-AbC' https://a.com; script-src *; ;object-src 'none'", false)
+	if got := strings.Join(p.Directives["script-src"], " "); got != "'self' 'nonce-AbC' https://a.com" {
+		t.Errorf("script-src = %s", got)
+	}
+	if got := strings.Join(p.Directives["object-src"], " "); got != "'none'" {
+		t.Errorf("object-src = %s", got)
+	}
+	if len(p.Directives) != 2 {
+		t.Errorf("directives %v", p.Directives)
+	}
+	if name, sources, ok := ParseCSP("default-src 'self'", false).Effective("img-src"); !ok || name != "default-src" || sources[0] != "'self'" {
+		t.Errorf("img-src does not fall back to default-src")
+	}
+	if _, _, ok := ParseCSP("default-src 'self'", false).Effective("base-uri"); ok {
+		t.Errorf("base-uri falls back to default-src")
+	}
+}
+
+func TestCSPEvaluate(t *testing.T) {
+	tests := []struct {
+		policy string
+		issues []string
+	}{
+		{
+			policy: "default-src 'self'; object-src 'none'; base-uri 'none'",
+			issues: []string{},
+		},
+		{
+			policy: "img-src *",
+			issues: []string{
+				"high missing script-src and default-src", "low img-src allows any host",
+				"low missing base-uri", "medium missing object-src",
+			},
+		},
+		{
+			policy: "script-src 'unsafe-inline' 'unsafe-eval' http://a.com *.b.com; object-src *; base-uri 'self'",
+			issues: []string{
+				"high script-src allows 'unsafe-inline'", "low script-src allows plain http host",
+				"low script-src allows wildcard subdomains", "medium object-src allows any host",
+				"medium script-src allows 'unsafe-eval'",
+			},
+		},
+		{
+			// unsafe-inline is ignored with a nonce, hosts with strict-dynamic
+			policy: "script-src 'nonce-abc' 'unsafe-inline' 'strict-dynamic' https: ajax.googleapis.com; object-src 'none'",
+			issues: []string{"medium missing base-uri"},
+		},
+		{
+			policy: "default-src https: ajax.googleapis.com; object-src 'none'; base-uri 'none'",
+			issues: []string{"high script-src allows any host", "high script-src allows host with JSONP endpoints or script gadgets"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			got := cspIssueTitles(ParseCSP(tt.policy, false).Evaluate())
+			sort.Strings(tt.issues)
+			if fmt.Sprint(got) != fmt.Sprint(tt.issues) {
+				t.Errorf("issues\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.issues, "\n"))
+			}
+		})
+	}
+}
+
+func TestCSPTrackerNonces(t *testing.T) {
+	tracker := NewCSPTracker()
+	policy := func(nonce string) *CSPPolicy {
+		return ParseCSP("script-src 'nonce-"+nonce+"'; object-src 'none'; base-uri 'none'", false)
+	}
+	for i := 1; i <= 100; i++ {
+		if issues := tracker.observe("https://a.com", policy(fmt.Sprint(i)), uint64(i)); len(issues) != 0 {
+			t.Fatalf("issues for a fresh nonce: %v", cspIssueTitles(issues))
+		}
+	}
+	// the same response sends the nonce in both headers
+	if issues := tracker.observe("https://a.com", policy("100"), 100); len(issues) != 0 {
+		t.Errorf("nonce of the same response is reported")
+	}
+	issues := tracker.observe("https://a.com", policy("7"), 101)
+	if fmt.Sprint(cspIssueTitles(issues)) != "[high nonce reused across responses]" {
+		t.Errorf("reused nonce: %v", cspIssueTitles(issues))
+	}
+
+	origins := tracker.Origins("https://a.com")
+	if len(origins) != 1 || len(origins[0].Policies) != 1 {
+		t.Fatalf("policies differing in nonces are not merged")
+	}
+	if r := origins[0].Policies[0]; r.Count != 102 || r.HistoryID != 1 {
+		t.Errorf("count %d, first history id %d", r.Count, r.HistoryID)
+	}
+	if len(origins[0].Issues) != 1 {
+		t.Errorf("origin issues %v", cspIssueTitles(origins[0].Issues))
+	}
+
+	for i := 0; i < cspMaxNonces*2; i++ {
+		tracker.observe("https://b.com", policy(fmt.Sprint("b", i)), uint64(1000+i))
+	}
+	o := tracker.origins["https://b.com"]
+	if len(o.nonces) != cspMaxNonces || len(o.nonceOrder) != cspMaxNonces {
+		t.Errorf("%d nonces kept, at most %d", len(o.nonces), cspMaxNonces)
+	}
+	if _, ok := o.nonces["b0"]; ok {
+		t.Errorf("the oldest nonce is kept")
+	}
+}
+
+func TestCSPTrackerMITMURL(t *testing.T) {
+	tracker := NewCSPTracker()
+	req := mitmRequest("GET", "https://Example.com:443/")
+	resp := &ResponseDTO{Response: &http.Response{StatusCode: 200, Header: http.Header{}}}
+	resp.Header.Set("Content-Security-Policy", "default-src 'self'; object-src 'none'; base-uri 'none'")
+	tracker.Check(req, resp)
+	if origins := tracker.Origins("https://example.com"); len(origins) != 1 {
+		t.Errorf("origin of a MITM'd url is not https://example.com: %v", tracker.Origins(""))
+	}
+}
@@ -25,14 +25,68 @@ var doc = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/config": {
+        "/ca.der": {
+            "get": {
+                "produces": [
+                    "application/x-x509-ca-cert"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "MITM CA certificate in DER",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/ca.pem": {
+            "get": {
+                "produces": [
+                    "application/x-pem-file"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "MITM CA certificate in PEM",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/cacheMode": {
             "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
+                "parameters": [
+                    {
+                        "description": "default mode, ordered url regexp rules and fuzzy match url regexps",
+                        "name": "modes",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.CacheModes"
+                        }
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "answer",
@@ -43,7 +97,7 @@ var doc = `{
                 }
             }
         },
-        "/infoPages": {
+        "/config": {
             "get": {
                 "consumes": [
                     "application/json"
@@ -61,7 +115,110 @@ var doc = `{
                 }
             }
         },
-        "/log": {
+        "/csp": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "scheme://host[:port], empty is all origins",
+                        "name": "origin",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/findings": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "reflection or passive check name",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "info, low, medium or high",
+                        "name": "severity",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "host",
+                        "name": "host",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "path regexp",
+                        "name": "path",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "reflection context: html, attribute, script, json or header",
+                        "name": "context",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/har": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "host regexp",
+                        "name": "host",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 time, inclusive",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 time, exclusive",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.HAR"
+                        }
+                    }
+                }
+            },
             "post": {
                 "consumes": [
                     "application/json"
@@ -69,6 +226,17 @@ var doc = `{
                 "produces": [
                     "application/json"
                 ],
+                "parameters": [
+                    {
+                        "description": "HAR to store in the cache",
+                        "name": "har",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.HAR"
+                        }
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "answer",
@@ -79,8 +247,124 @@ var doc = `{
                 }
             }
         },
-        "/navigatePage": {
-            "post": {
+        "/history": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "request method",
+                        "name": "method",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "host",
+                        "name": "host",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "path regexp",
+                        "name": "path",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "response status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 time, inclusive",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 time, exclusive",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "skip first entries",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size, 100 by default",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/history/{id}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "history id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.HistoryEntry"
+                        }
+                    }
+                }
+            }
+        },
+        "/history/{id}/events": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "history id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.SSEEvent"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/infoPages": {
+            "get": {
                 "consumes": [
                     "application/json"
                 ],
@@ -97,11 +381,163 @@ var doc = `{
                 }
             }
         },
-        "/reloadPage": {
+        "/intercept": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "description": "what to hold, scope rules and timeout in seconds",
+                        "name": "config",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.InterceptConfig"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/intercept/queue": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/intercept/queue/{id}": {
             "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "item id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/intercept/queue/{id}/drop": {
+            "post": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "item id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/intercept/queue/{id}/forward": {
+            "post": {
                 "consumes": [
                     "application/json"
                 ],
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "item id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "raw: edited http message, empty forwards it unchanged",
+                        "name": "edit",
+                        "in": "body",
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/intercept/stream": {
+            "get": {
+                "produces": [
+                    "text/event-stream"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "server-sent events: item",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/intruder": {
+            "get": {
                 "produces": [
                     "application/json"
                 ],
@@ -113,6 +549,1460 @@ var doc = `{
                         }
                     }
                 }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "description": "base request (history id or raw with §markers§), positions, mode, payload sets, concurrency, rate, grep",
+                        "name": "attack",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.IntruderAttack"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.IntruderRun"
+                        }
+                    }
+                }
+            }
+        },
+        "/intruder/{id}": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "run id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.IntruderRun"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "run id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/log": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/map": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "description": "map local and map remote rules",
+                        "name": "rules",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.MapRules"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/navigatePage": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/passiveChecks": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/redirects": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "start or final url contains",
+                        "name": "url",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/relax": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "description": "global switch and ordered host/path policies",
+                        "name": "policies",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.RelaxPolicies"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/relaxToggle": {
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "description": "policy name (empty switches all relaxations) and enabled",
+                        "name": "toggle",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/reloadPage": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/repeat": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "description": "id (history id) or raw request, scheme for raw origin-form requests (https by default), edits",
+                        "name": "repeat",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.RepeatEdits"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.RepeatResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/replace": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "description": "ordered match and replace rules",
+                        "name": "rules",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.ReplaceRule"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/replaceToggle": {
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "description": "rule id and enabled",
+                        "name": "toggle",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/routes": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "description": "upstreams, routes and health check",
+                        "name": "routes",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Router"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/scope": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "description": "ordered include/exclude rules, saved to the --scope file",
+                        "name": "scope",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.Scope"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/upstreams": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.UpstreamHealth"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/websocket/conversations": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "goproxy session id",
+                        "name": "session",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "handshake request hash",
+                        "name": "hash",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/websocket/frames": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "conversation id",
+                        "name": "id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "goproxy session id",
+                        "name": "session",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "client or server",
+                        "name": "direction",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "frame opcode",
+                        "name": "opcode",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "answer",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "main.CacheModeRule": {
+            "type": "object",
+            "properties": {
+                "match": {
+                    "type": "string"
+                },
+                "mode": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.CacheModes": {
+            "type": "object",
+            "properties": {
+                "default": {
+                    "type": "string"
+                },
+                "fuzzy": {
+                    "description": "url regexps where replay falls back to the closest recorded request",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.CacheModeRule"
+                    }
+                }
+            }
+        },
+        "main.HAR": {
+            "type": "object",
+            "properties": {
+                "log": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.HARLog"
+                }
+            }
+        },
+        "main.HARContent": {
+            "type": "object",
+            "properties": {
+                "compression": {
+                    "type": "integer"
+                },
+                "encoding": {
+                    "type": "string"
+                },
+                "mimeType": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "text": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.HARCreator": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.HAREntry": {
+            "type": "object",
+            "properties": {
+                "_hash": {
+                    "description": "cache hash of the exported request",
+                    "type": "string"
+                },
+                "cache": {
+                    "type": "object"
+                },
+                "request": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.HARRequest"
+                },
+                "response": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.HARResponse"
+                },
+                "startedDateTime": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "number"
+                },
+                "timings": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.HARTimings"
+                }
+            }
+        },
+        "main.HARLog": {
+            "type": "object",
+            "properties": {
+                "creator": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.HARCreator"
+                },
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.HAREntry"
+                    }
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.HARNameValue": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.HARPostData": {
+            "type": "object",
+            "properties": {
+                "encoding": {
+                    "type": "string"
+                },
+                "mimeType": {
+                    "type": "string"
+                },
+                "params": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.HARNameValue"
+                    }
+                },
+                "text": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.HARRequest": {
+            "type": "object",
+            "properties": {
+                "bodySize": {
+                    "type": "integer"
+                },
+                "cookies": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.HARNameValue"
+                    }
+                },
+                "headers": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.HARNameValue"
+                    }
+                },
+                "headersSize": {
+                    "type": "integer"
+                },
+                "httpVersion": {
+                    "type": "string"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "postData": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.HARPostData"
+                },
+                "queryString": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.HARNameValue"
+                    }
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.HARResponse": {
+            "type": "object",
+            "properties": {
+                "bodySize": {
+                    "type": "integer"
+                },
+                "content": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.HARContent"
+                },
+                "cookies": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.HARNameValue"
+                    }
+                },
+                "headers": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.HARNameValue"
+                    }
+                },
+                "headersSize": {
+                    "type": "integer"
+                },
+                "httpVersion": {
+                    "type": "string"
+                },
+                "redirectURL": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "statusText": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.HARTimings": {
+            "type": "object",
+            "properties": {
+                "receive": {
+                    "type": "number"
+                },
+                "send": {
+                    "type": "number"
+                },
+                "wait": {
+                    "type": "number"
+                }
+            }
+        },
+        "main.HealthCheck": {
+            "type": "object",
+            "properties": {
+                "interval": {
+                    "description": "seconds between checks, negative disables them",
+                    "type": "integer"
+                },
+                "target": {
+                    "description": "host:port dialed through every upstream, empty only dials the first proxy",
+                    "type": "string"
+                },
+                "timeout": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.HistoryEntry": {
+            "type": "object",
+            "properties": {
+                "cache_mode": {
+                    "type": "string"
+                },
+                "csp": {
+                    "description": "original values, relax policies may rewrite them",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "csp_report_only": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "error": {
+                    "type": "string"
+                },
+                "events": {
+                    "description": "server sent events recorded",
+                    "type": "integer"
+                },
+                "from_cache": {
+                    "type": "boolean"
+                },
+                "hash": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "map_local": {
+                    "description": "file served instead of the url",
+                    "type": "string"
+                },
+                "map_remote": {
+                    "description": "url before a map remote rule rewrote it",
+                    "type": "string"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "mime": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "repeat_of": {
+                    "description": "id of the entry this one is repeated from",
+                    "type": "integer"
+                },
+                "replaced": {
+                    "description": "ids of the match and replace rules applied",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "request_size": {
+                    "type": "integer"
+                },
+                "response_size": {
+                    "type": "integer"
+                },
+                "session": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "streamed": {
+                    "description": "body went to the client as it came, see stream.go",
+                    "type": "boolean"
+                },
+                "time": {
+                    "type": "string"
+                },
+                "timings": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.HistoryTimings"
+                },
+                "truncated": {
+                    "description": "recording stopped at the record limit, the body is not cached",
+                    "type": "boolean"
+                },
+                "url": {
+                    "type": "string"
+                },
+                "x_xss_protection": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.HistoryTimings": {
+            "type": "object",
+            "properties": {
+                "receive": {
+                    "description": "response headers to the end of the body",
+                    "type": "number"
+                },
+                "total": {
+                    "type": "number"
+                },
+                "wait": {
+                    "description": "request handler to response headers",
+                    "type": "number"
+                }
+            }
+        },
+        "main.InterceptConfig": {
+            "type": "object",
+            "properties": {
+                "requests": {
+                    "type": "boolean"
+                },
+                "responses": {
+                    "type": "boolean"
+                },
+                "scope": {
+                    "description": "same rules as the global scope, empty is everything",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ScopeRule"
+                    }
+                },
+                "timeout": {
+                    "description": "seconds an item is held, forgotten ones don't hang the browser",
+                    "type": "integer"
+                },
+                "timeout_action": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.IntruderAttack": {
+            "type": "object",
+            "properties": {
+                "concurrency": {
+                    "type": "integer"
+                },
+                "grep": {
+                    "description": "response regexps, matches are in IntruderResult.Grep",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "description": "history id of the base request",
+                    "type": "integer"
+                },
+                "mode": {
+                    "type": "string"
+                },
+                "payloads": {
+                    "type": "array",
+                    "items": {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        }
+                    }
+                },
+                "positions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.IntruderPosition"
+                    }
+                },
+                "rate": {
+                    "description": "requests per second, 0 is unlimited",
+                    "type": "number"
+                },
+                "raw": {
+                    "description": "or raw base request, §markers§ add raw positions",
+                    "type": "string"
+                },
+                "scheme": {
+                    "description": "for raw origin-form requests, https by default",
+                    "type": "string"
+                }
+            }
+        },
+        "main.IntruderPosition": {
+            "type": "object",
+            "properties": {
+                "end": {
+                    "type": "integer"
+                },
+                "kind": {
+                    "description": "query, form, json, header or raw",
+                    "type": "string"
+                },
+                "name": {
+                    "description": "param or header name, dot separated json path",
+                    "type": "string"
+                },
+                "start": {
+                    "description": "raw byte offsets [start, end) in the raw request without markers",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.IntruderResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "grep": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "history_id": {
+                    "description": "history id of the request sent",
+                    "type": "integer"
+                },
+                "index": {
+                    "type": "integer"
+                },
+                "length": {
+                    "type": "integer"
+                },
+                "payloads": {
+                    "description": "by position, null is the original value (sniper)",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "timings": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.HistoryTimings"
+                }
+            }
+        },
+        "main.IntruderRun": {
+            "type": "object",
+            "properties": {
+                "attack": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.IntruderAttack"
+                },
+                "done": {
+                    "type": "integer"
+                },
+                "ended": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.IntruderResult"
+                    }
+                },
+                "started": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.MapLocalRule": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "match": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.MapRemoteRule": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "match": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.MapRules": {
+            "type": "object",
+            "properties": {
+                "local": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.MapLocalRule"
+                    }
+                },
+                "remote": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.MapRemoteRule"
+                    }
+                }
+            }
+        },
+        "main.RedirectHop": {
+            "type": "object",
+            "properties": {
+                "location": {
+                    "type": "string"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.RelaxPolicies": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "policies": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.RelaxPolicy"
+                    }
+                }
+            }
+        },
+        "main.RelaxPolicy": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "host": {
+                    "description": "host and path regexps, empty matches everything",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "protections": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.Relaxation"
+                    }
+                }
+            }
+        },
+        "main.Relaxation": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "value": {
+                    "description": "header value for replace",
+                    "type": "string"
+                }
+            }
+        },
+        "main.RepeatEdits": {
+            "type": "object",
+            "properties": {
+                "body": {
+                    "type": "string"
+                },
+                "header": {
+                    "description": "empty value removes the header",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "method": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.RepeatResult": {
+            "type": "object",
+            "properties": {
+                "body": {
+                    "type": "string"
+                },
+                "encoding": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "id": {
+                    "description": "history id of the repeat",
+                    "type": "integer"
+                },
+                "repeat_of": {
+                    "type": "integer"
+                },
+                "request": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.RequestDTO"
+                },
+                "response": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.ResponseDTO"
+                },
+                "timings": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.HistoryTimings"
+                }
+            }
+        },
+        "main.ReplaceRule": {
+            "type": "object",
+            "properties": {
+                "comment": {
+                    "type": "string"
+                },
+                "enabled": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "match": {
+                    "type": "string"
+                },
+                "regex": {
+                    "type": "boolean"
+                },
+                "replace": {
+                    "description": "$1 and ${name} are expanded for regex rules",
+                    "type": "string"
+                },
+                "scope": {
+                    "description": "same rules as the global scope, empty is everything",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ScopeRule"
+                    }
+                },
+                "target": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.RequestDTO": {
+            "type": "object",
+            "properties": {
+                "redirectChain": {
+                    "description": "redirects which led to this request",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.RedirectHop"
+                    }
+                }
+            }
+        },
+        "main.ResponseDTO": {
+            "type": "object"
+        },
+        "main.Route": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "scope": {
+                    "description": "same rules as the global scope, empty is everything",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ScopeRule"
+                    }
+                },
+                "upstreams": {
+                    "description": "names tried in order",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.Router": {
+            "type": "object",
+            "properties": {
+                "health_check": {
+                    "type": "object",
+                    "$ref": "#/definitions/main.HealthCheck"
+                },
+                "routes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.Route"
+                    }
+                },
+                "upstreams": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.Upstream"
+                    }
+                }
+            }
+        },
+        "main.SSEEvent": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "string"
+                },
+                "event": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "index": {
+                    "type": "integer"
+                },
+                "retry": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.Scope": {
+            "type": "object",
+            "properties": {
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ScopeRule"
+                    }
+                }
+            }
+        },
+        "main.ScopeRule": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "host": {
+                    "description": "wildcard like *.example.com",
+                    "type": "string"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "path": {
+                    "description": "path regexp",
+                    "type": "string"
+                },
+                "port": {
+                    "type": "integer"
+                },
+                "protocol": {
+                    "description": "http or https",
+                    "type": "string"
+                }
+            }
+        },
+        "main.Upstream": {
+            "type": "object",
+            "properties": {
+                "chain": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.UpstreamHealth": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "healthy": {
+                    "type": "boolean"
+                },
+                "last_check": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                }
             }
         }
     }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"mime"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// decoded bodies over this are not decoded, a small compressed body can be huge
+const maxDecodedBody = 64 << 20
+
+// decodeBody undoes gzip, deflate and brotli content encodings
+func decodeBody(encoding string, body []byte) ([]byte, error) {
+	var r io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		r = gr
+	case "deflate":
+		// zlib wrapped as the rfc says, raw deflate from some servers
+		if zr, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+			r = zr
+		} else {
+			r = flate.NewReader(bytes.NewReader(body))
+		}
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return nil, errors.Errorf("unsupported content encoding %q", encoding)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxDecodedBody+1))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(data) > maxDecodedBody {
+		return nil, errors.Errorf("%s body decodes to more than %d bytes", encoding, maxDecodedBody)
+	}
+	return data, nil
+}
+
+// encodeBody is the reverse of decodeBody, deflate is zlib wrapped
+func encodeBody(encoding string, body []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		w = gzip.NewWriter(buf)
+	case "deflate":
+		w = zlib.NewWriter(buf)
+	case "br":
+		w = brotli.NewWriter(buf)
+	default:
+		return nil, errors.Errorf("unsupported content encoding %q", encoding)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf.Bytes(), nil
+}
+
+var metaCharsetRe = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([\w:.-]+)`)
+
+// textCharset is the charset of a text body from Content-Type or an html meta tag, empty if unknown
+func textCharset(contentType string, body []byte) string {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if charset := params["charset"]; charset != "" {
+		return charset
+	}
+	if mediaType == "text/html" || mediaType == "" {
+		// browsers look at the first 1024 bytes
+		head := body
+		if len(head) > 1024 {
+			head = head[:1024]
+		}
+		if m := metaCharsetRe.FindSubmatch(head); m != nil {
+			return string(m[1])
+		}
+	}
+	return ""
+}
+
+// toUTF8 converts a body in charset, unknown charsets and utf-8 ones are returned as is
+func toUTF8(charset string, body []byte) ([]byte, bool) {
+	if charset == "" {
+		return body, false
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return body, false
+	}
+	if name, _ := htmlindex.Name(enc); name == "utf-8" {
+		return body, false
+	}
+	data, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return body, false
+	}
+	return data, true
+}
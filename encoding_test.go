@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	text := bytes.Repeat([]byte("hello anothergoproxy "), 100)
+	rawDeflate := &bytes.Buffer{}
+	w, _ := flate.NewWriter(rawDeflate, flate.DefaultCompression)
+	w.Write(text)
+	w.Close()
+
+	for _, encoding := range []string{"", "identity", "gzip", "x-gzip", "deflate", "br", " GZIP "} {
+		t.Run(encoding, func(t *testing.T) {
+			encoded, err := encodeBody(encoding, text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := decodeBody(encoding, encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, text) {
+				t.Errorf("decoded body differs")
+			}
+		})
+	}
+	t.Run("raw deflate", func(t *testing.T) {
+		decoded, err := decodeBody("deflate", rawDeflate.Bytes())
+		if err != nil || !bytes.Equal(decoded, text) {
+			t.Errorf("raw deflate is not decoded: %v", err)
+		}
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		if _, err := decodeBody("zstd", text); err == nil {
+			t.Errorf("no error")
+		}
+	})
+	t.Run("bomb", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		gw, _ := gzip.NewWriterLevel(buf, gzip.BestCompression)
+		zeros := make([]byte, 1<<20)
+		for i := 0; i <= maxDecodedBody>>20; i++ {
+			gw.Write(zeros)
+		}
+		gw.Close()
+		if _, err := decodeBody("gzip", buf.Bytes()); err == nil {
+			t.Errorf("%d bytes decoding to more than %d are decoded", buf.Len(), maxDecodedBody)
+		}
+	})
+}
+
+func TestTextCharset(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		charset     string
+	}{
+		{"text/html; charset=windows-1251", "", "windows-1251"},
+		{"text/html", `<html><head><meta charset="koi8-r"></head>`, "koi8-r"},
+		{"text/html", `<meta http-equiv="Content-Type" content="text/html; charset=Shift_JIS">`, "Shift_JIS"},
+		{"", `<meta charset=utf-8>`, "utf-8"},
+		{"application/json", `<meta charset="koi8-r">`, ""},
+		{"text/html", "<html></html>", ""},
+	}
+	for _, tt := range tests {
+		if charset := textCharset(tt.contentType, []byte(tt.body)); charset != tt.charset {
+			t.Errorf("textCharset(%q, %q) = %q, want %q", tt.contentType, tt.body, charset, tt.charset)
+		}
+	}
+}
+
+func TestToUTF8(t *testing.T) {
+	tests := []struct {
+		charset   string
+		body      []byte
+		text      string
+		converted bool
+	}{
+		{"windows-1251", []byte{0xcf, 0xf0, 0xe8, 0xe2, 0xe5, 0xf2}, "Привет", true},
+		{"koi8-r", []byte{0xf0, 0xd2, 0xc9, 0xd7, 0xc5, 0xd4}, "Привет", true},
+		{"utf-8", []byte("Привет"), "Привет", false},
+		{"", []byte("hello"), "hello", false},
+		{"no-such-charset", []byte("hello"), "hello", false},
+	}
+	for _, tt := range tests {
+		text, converted := toUTF8(tt.charset, tt.body)
+		if string(text) != tt.text || converted != tt.converted {
+			t.Errorf("toUTF8(%q) = %q %v, want %q %v", tt.charset, text, converted, tt.text, tt.converted)
+		}
+	}
+}
+
+// the cache keeps bodies decoded and replays them as received
+func TestCacheReplaysEncodedBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anothergoproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(o Options) { options = o }(options)
+	options.OutputPath = dir
+	if err = os.MkdirAll(options.CachePath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	bolt, err := NewCacheBolt(options.CacheDBFilename())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+	file, _ := NewCacheFile()
+
+	text := []byte("<html>hello</html>")
+	// level 1 differs from what encodeBody would produce
+	buf := &bytes.Buffer{}
+	gw, _ := gzip.NewWriterLevel(buf, gzip.BestSpeed)
+	gw.Write(text)
+	gw.Close()
+	received := buf.Bytes()
+
+	for name, cache := range map[string]ReqRespCacheI{"file": file, "bolt": bolt} {
+		t.Run(name, func(t *testing.T) {
+			req := newTestRequest(http.MethodGet, "http://example.com/"+name, "", "")
+			header := http.Header{}
+			header.Set("Content-Encoding", "gzip")
+			header.Set("Content-Length", strconv.Itoa(len(received)))
+			resp := &ResponseDTO{
+				Response: &http.Response{StatusCode: 200, Header: header, ContentLength: int64(len(received))},
+				body:     received,
+			}
+			if err := cache.Store(req, resp); err != nil {
+				t.Fatal(err)
+			}
+			if name == "file" {
+				stored, err := ioutil.ReadFile(file.filename(req.Hash(), "resp_body"))
+				if err != nil || !bytes.Equal(stored, text) {
+					t.Errorf("body is not stored decoded: %q %v", stored, err)
+				}
+			}
+			loaded, err := cache.Load(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(loaded.body, received) {
+				t.Errorf("replayed body differs from the received one")
+			}
+			if loaded.Header.Get("Content-Length") != strconv.Itoa(len(received)) {
+				t.Errorf("Content-Length = %s", loaded.Header.Get("Content-Length"))
+			}
+		})
+	}
+}
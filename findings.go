@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltFindings = []byte("findings")
+
+const (
+	SeverityInfo   = "info"
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+type Finding struct {
+	// reflection or the passive check name
+	Type     string `json:"type"`
+	Severity string `json:"severity"`
+	Title    string `json:"title"`
+	Host     string `json:"host"`
+	Path     string `json:"path"`
+	URL      string `json:"url"`
+	// history id of the first exchange it was found in
+	HistoryID uint64 `json:"history_id"`
+	// reflected parameter
+	Param *RequestParam `json:"param,omitempty"`
+	// html, attribute, script, json or header
+	Context string `json:"context,omitempty"`
+	// header name or a snippet around the match
+	Evidence  string    `json:"evidence,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Count     int       `json:"count"`
+}
+
+// Key dedups findings of the same type and host, path, parameter and context
+func (f *Finding) Key() string {
+	parts := []string{f.Type, f.Host, f.Path, f.Title, f.Context}
+	if f.Param != nil {
+		parts = append(parts, f.Param.Kind, f.Param.Name)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// FindingFilter zero fields match everything
+type FindingFilter struct {
+	Type     string
+	Severity string
+	Host     string
+	// path regexp
+	Path    string
+	Context string
+
+	path *regexp.Regexp
+}
+
+func (f *FindingFilter) Match(e *Finding) bool {
+	return (f.Type == "" || f.Type == e.Type) &&
+		(f.Severity == "" || f.Severity == e.Severity) &&
+		(f.Host == "" || strings.EqualFold(f.Host, e.Host)) &&
+		(f.path == nil || f.path.MatchString(e.Path)) &&
+		(f.Context == "" || f.Context == e.Context)
+}
+
+// Findings keeps deduplicated findings in a bbolt file
+type Findings struct {
+	db *bolt.DB
+}
+
+func NewFindings(filename string) (*Findings, error) {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", filename)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltFindings)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.WithStack(err)
+	}
+	return &Findings{db: db}, nil
+}
+
+func (s *Findings) Close() error {
+	return s.db.Close()
+}
+
+// Add stores f or bumps Count and LastSeen of the same one
+func (s *Findings) Add(f *Finding) error {
+	if f.FirstSeen.IsZero() {
+		f.FirstSeen = time.Now()
+	}
+	f.LastSeen, f.Count = f.FirstSeen, 1
+	key := []byte(f.Key())
+	err := s.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltFindings)
+		stored := f
+		if data := b.Get(key); data != nil {
+			old := &Finding{}
+			if err := json.Unmarshal(data, old); err != nil {
+				return err
+			}
+			old.LastSeen = f.LastSeen
+			old.Count++
+			stored = old
+		}
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, data)
+	})
+	return errors.WithStack(err)
+}
+
+func (s *Findings) Find(filter FindingFilter) ([]*Finding, error) {
+	if filter.Path != "" {
+		var err error
+		if filter.path, err = regexp.Compile(filter.Path); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	res := make([]*Finding, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltFindings).ForEach(func(k, v []byte) error {
+			f := &Finding{}
+			if err := json.Unmarshal(v, f); err != nil {
+				return err
+			}
+			if filter.Match(f) {
+				res = append(res, f)
+			}
+			return nil
+		})
+	})
+	return res, errors.WithStack(err)
+}
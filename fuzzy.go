@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+const fuzzyMatchHeader = "X-Anotherproxy-Fuzzy-Match"
+
+// similarKey groups requests fuzzy matching is done between
+func similarKey(req *RequestDTO) string {
+	return fmt.Sprintf("%s %s%s", req.Method, req.URL.Host, req.URL.Path)
+}
+
+// similarity of query and body params: 1 for equal value, 0.5 for the same name, divided by names count
+func similarity(a, b *RequestDTO) float64 {
+	fields := func(req *RequestDTO) map[string]string {
+		res := make(map[string]string)
+		for _, p := range req.Params() {
+			if p.Kind == ParamQuery || p.Kind == ParamForm || p.Kind == ParamJSON {
+				res[p.Kind+":"+p.Name] = p.Value
+			}
+		}
+		return res
+	}
+	fa, fb := fields(a), fields(b)
+	names := make(map[string]struct{})
+	for k := range fa {
+		names[k] = struct{}{}
+	}
+	for k := range fb {
+		names[k] = struct{}{}
+	}
+	if len(names) == 0 {
+		return 1
+	}
+	score := 0.0
+	for k := range names {
+		va, okA := fa[k]
+		vb, okB := fb[k]
+		switch {
+		case okA && okB && va == vb:
+			score += 1
+		case okA && okB:
+			score += 0.5
+		}
+	}
+	return score / float64(len(names))
+}
+
+// LoadClosest loads response of the most similar request with the same method, host and path
+func LoadClosest(cache ReqRespCacheI, req *RequestDTO) (*ResponseDTO, error) {
+	candidates, err := cache.Similar(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	bestHash, bestScore := "", -1.0
+	for hash, candidate := range candidates {
+		if score := similarity(req, candidate); score > bestScore || score == bestScore && hash < bestHash {
+			bestHash, bestScore = hash, score
+		}
+	}
+	if bestHash == "" {
+		return nil, errors.Errorf("no similar request for %s", similarKey(req))
+	}
+	resp, err := cache.LoadHash(bestHash)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp.Header = resp.Header.Clone()
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.Header.Set(fuzzyMatchHeader, fmt.Sprintf("%s; score=%.2f", bestHash, bestScore))
+	return resp, nil
+}
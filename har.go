@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// HAR 1.2, see http://www.softwareishard.com/blog/har-12-spec/
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator HARCreator  `json:"creator"`
+	Entries []*HAREntry `json:"entries"`
+}
+
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	// cache hash of the exported request
+	Hash string `json:"_hash,omitempty"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type HARPostData struct {
+	MimeType string         `json:"mimeType"`
+	Params   []HARNameValue `json:"params,omitempty"`
+	Text     string         `json:"text"`
+	Encoding string         `json:"encoding,omitempty"`
+}
+
+type HARContent struct {
+	Size        int    `json:"size"`
+	Compression int    `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// HARFilter zero fields match everything
+type HARFilter struct {
+	// host regexp
+	Host string
+	From time.Time
+	To   time.Time
+}
+
+// ExportHAR converts cache entries matching filter to HAR ordered by time
+func ExportHAR(cache ReqRespCacheI, filter HARFilter) (*HAR, error) {
+	var hostRe *regexp.Regexp
+	if filter.Host != "" {
+		var err error
+		if hostRe, err = regexp.Compile(filter.Host); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	entries, err := cache.Find(CacheFilter{From: filter.From, To: filter.To})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+
+	har := NewHAR()
+	for _, entry := range entries {
+		if hostRe != nil && !hostRe.MatchString(entry.Host) {
+			continue
+		}
+		req, resp, err := cache.LoadEntry(entry.Hash)
+		if err != nil {
+			logrus.WithError(err).WithField("hash", entry.Hash).Warn("load cache entry")
+			continue
+		}
+		harEntry := NewHAREntry(req, resp, entry.Time)
+		harEntry.Hash = entry.Hash
+		har.Log.Entries = append(har.Log.Entries, harEntry)
+	}
+	return har, nil
+}
+
+// ImportHAR stores every entry to cache under the hash of its request
+func ImportHAR(cache ReqRespCacheI, har *HAR) (int, error) {
+	n := 0
+	for i, harEntry := range har.Log.Entries {
+		req, resp, err := harEntry.DTO()
+		if err != nil {
+			logrus.WithError(err).WithField("entry", i).Warn("skip har entry")
+			continue
+		}
+		entry := NewCacheEntry(req.Hash(), req, resp, harEntry.StartedDateTime)
+		if err = cache.StoreEntry(entry, req, resp); err != nil {
+			return n, errors.WithStack(err)
+		}
+		n++
+	}
+	return n, nil
+}
+
+func NewHAR() *HAR {
+	return &HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "anothergoproxy", Version: "1.0"},
+		Entries: make([]*HAREntry, 0),
+	}}
+}
+
+func NewHAREntry(req *RequestDTO, resp *ResponseDTO, t time.Time) *HAREntry {
+	e := &HAREntry{StartedDateTime: t}
+
+	e.Request = HARRequest{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		HTTPVersion: harProto(req.Proto),
+		Cookies:     make([]HARNameValue, 0),
+		Headers:     harHeaders(req.Header),
+		QueryString: make([]HARNameValue, 0),
+		HeadersSize: -1,
+		BodySize:    len(req.body),
+	}
+	for _, c := range req.Cookies() {
+		e.Request.Cookies = append(e.Request.Cookies, HARNameValue{c.Name, c.Value})
+	}
+	for _, p := range req.Params() {
+		if p.Kind == "query" {
+			e.Request.QueryString = append(e.Request.QueryString, HARNameValue{p.Name, p.Value})
+		}
+	}
+	if len(req.body) > 0 {
+		text, encoding := harText(req.body)
+		e.Request.PostData = &HARPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+		}
+		for _, p := range req.Params() {
+			if p.Kind == "form" {
+				e.Request.PostData.Params = append(e.Request.PostData.Params, HARNameValue{p.Name, p.Value})
+			}
+		}
+	}
+
+	// HAR content is decoded, the stored body may be compressed
+	body, err := decodeBody(resp.Header.Get("Content-Encoding"), resp.body)
+	if err != nil {
+		logrus.WithError(err).WithField("url", req.URL.String()).Warn("decode body")
+		body = resp.body
+	}
+	text, encoding := harText(body)
+	e.Response = HARResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: harProto(resp.Proto),
+		Cookies:     make([]HARNameValue, 0),
+		Headers:     harHeaders(resp.Header),
+		Content: HARContent{
+			Size:        len(body),
+			Compression: len(body) - len(resp.body),
+			MimeType:    resp.Header.Get("Content-Type"),
+			Text:        text,
+			Encoding:    encoding,
+		},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(resp.body),
+	}
+	for _, c := range (&http.Response{Header: resp.Header}).Cookies() {
+		e.Response.Cookies = append(e.Response.Cookies, HARNameValue{c.Name, c.Value})
+	}
+	return e
+}
+
+// DTO builds cacheable request and response, the response body is stored decoded
+func (e *HAREntry) DTO() (*RequestDTO, *ResponseDTO, error) {
+	u, err := url.Parse(e.Request.URL)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if u.Host == "" {
+		return nil, nil, errors.Errorf("url without host %q", e.Request.URL)
+	}
+	var reqBody []byte
+	if e.Request.PostData != nil {
+		if reqBody, err = harBody(e.Request.PostData.Text, e.Request.PostData.Encoding); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+	r, err := http.NewRequest(e.Request.Method, u.String(), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	for _, h := range e.Request.Headers {
+		// http2 pseudo headers from devtools
+		if strings.HasPrefix(h.Name, ":") || strings.EqualFold(h.Name, "Host") {
+			continue
+		}
+		r.Header.Add(h.Name, h.Value)
+	}
+	req := NewRequestDTO(r)
+
+	respBody, err := harBody(e.Response.Content.Text, e.Response.Content.Encoding)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	header := make(http.Header)
+	for _, h := range e.Response.Headers {
+		if strings.HasPrefix(h.Name, ":") {
+			continue
+		}
+		header.Add(h.Name, h.Value)
+	}
+	header.Del("Content-Encoding")
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(respBody)))
+	// devtools writes "http/2.0", replayed as HTTP/1.1
+	major, minor, ok := http.ParseHTTPVersion(strings.ToUpper(e.Response.HTTPVersion))
+	if !ok || major != 1 {
+		major, minor = 1, 1
+	}
+	resp, err := NewResponseDTO(&http.Response{
+		StatusCode:    e.Response.Status,
+		Status:        strings.TrimSpace(strconv.Itoa(e.Response.Status) + " " + e.Response.StatusText),
+		Proto:         fmt.Sprintf("HTTP/%d.%d", major, minor),
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        header,
+		ContentLength: int64(len(respBody)),
+		Body:          ioutil.NopCloser(bytes.NewReader(respBody)),
+	})
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return req, resp, nil
+}
+
+func harHeaders(header http.Header) []HARNameValue {
+	res := make([]HARNameValue, 0, len(header))
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			res = append(res, HARNameValue{name, value})
+		}
+	}
+	return res
+}
+
+func harProto(proto string) string {
+	if proto == "" {
+		return "HTTP/1.1"
+	}
+	return proto
+}
+
+// harText returns body as is when it is utf-8 text, in base64 otherwise
+func harText(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func harBody(text, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+// NewHARFilter parses from and to as RFC3339, empty means no limit
+func NewHARFilter(host, from, to string) (HARFilter, error) {
+	filter := HARFilter{Host: host}
+	var err error
+	filter.From, filter.To, err = parseTimeRange(from, to)
+	return filter, err
+}
+
+func parseTimeRange(from, to string) (time.Time, time.Time, error) {
+	var fromTime, toTime time.Time
+	var err error
+	if from != "" {
+		if fromTime, err = time.Parse(time.RFC3339, from); err != nil {
+			return fromTime, toTime, errors.Wrap(err, "from")
+		}
+	}
+	if to != "" {
+		if toTime, err = time.Parse(time.RFC3339, to); err != nil {
+			return fromTime, toTime, errors.Wrap(err, "to")
+		}
+	}
+	return fromTime, toTime, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// exported by chrome devtools, the url has no port
+const devtoolsHAR = `{"log": {"version": "1.2", "creator": {"name": "WebInspector", "version": "537.36"}, "entries": [{
+  "startedDateTime": "2020-05-01T10:00:00.000Z",
+  "request": {"method": "GET", "url": "https://example.com/app.js?v=1", "httpVersion": "http/2.0",
+    "headers": [{"name": ":authority", "value": "example.com"}, {"name": ":path", "value": "/app.js?v=1"}, {"name": "accept", "value": "*/*"}],
+    "cookies": [], "queryString": [{"name": "v", "value": "1"}], "headersSize": -1, "bodySize": 0},
+  "response": {"status": 200, "statusText": "", "httpVersion": "http/2.0",
+    "headers": [{"name": "content-type", "value": "application/javascript"}, {"name": "content-encoding", "value": "gzip"}],
+    "cookies": [], "content": {"size": 13, "mimeType": "application/javascript", "text": "console.log()"},
+    "redirectURL": "", "headersSize": -1, "bodySize": -1}
+}]}}`
+
+// mitmRequest is what goproxy hands to the handlers for an https request inside a CONNECT tunnel
+func mitmRequest(method, rawurl string) *RequestDTO {
+	req := httptest.NewRequest(method, rawurl, nil)
+	normalizeHandler(req, nil)
+	return NewRequestDTO(req)
+}
+
+func TestImportHARReplaysMITMRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anothergoproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(o Options) { options = o }(options)
+	options.OutputPath = dir
+	if err = os.MkdirAll(options.CachePath(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	bolt, err := NewCacheBolt(options.CacheDBFilename())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+	file, _ := NewCacheFile()
+
+	for name, cache := range map[string]ReqRespCacheI{"file": file, "bolt": bolt} {
+		t.Run(name, func(t *testing.T) {
+			har := &HAR{}
+			if err := json.Unmarshal([]byte(devtoolsHAR), har); err != nil {
+				t.Fatal(err)
+			}
+			if n, err := ImportHAR(cache, har); err != nil || n != 1 {
+				t.Fatalf("imported %d: %v", n, err)
+			}
+			resp, err := cache.Load(mitmRequest(http.MethodGet, "https://EXAMPLE.com:443/app.js?v=1"))
+			if err != nil {
+				t.Fatalf("imported entry is not replayed: %v", err)
+			}
+			if string(resp.body) != "console.log()" {
+				t.Errorf("body %q", resp.body)
+			}
+		})
+		t.Run(name+" recorded with the port", func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://example.com:443/old.js", nil)
+			resp := &ResponseDTO{Response: &http.Response{StatusCode: 200, Header: http.Header{}}, body: []byte("old")}
+			if err := cache.Store(&RequestDTO{Request: req, body: []byte{}}, resp); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := cache.Load(mitmRequest(http.MethodGet, "https://example.com:443/old.js"))
+			if err != nil || string(loaded.body) != "old" {
+				t.Errorf("entry keyed with :443 is not loaded: %v", err)
+			}
+		})
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := map[string]string{
+		"https://Example.COM:443/a?b=1": "https://example.com/a?b=1",
+		"http://example.com:80/":        "http://example.com/",
+		"wss://example.com:443/ws":      "wss://example.com/ws",
+		"https://example.com:80/":       "https://example.com:80/",
+		"http://example.com:443/":       "http://example.com:443/",
+		"https://example.com:8443/":     "https://example.com:8443/",
+		"https://[::1]:443/":            "https://[::1]/",
+	}
+	for raw, want := range tests {
+		req := httptest.NewRequest(http.MethodGet, raw, nil)
+		normalizeURL(req.URL)
+		if got := req.URL.String(); got != want {
+			t.Errorf("%s -> %s, want %s", raw, got, want)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltHistory = []byte("history")
+	// requests are kept to be repeated, by the same key
+	boltHistoryRequests      = []byte("history_requests")
+	boltHistoryRequestBodies = []byte("history_request_bodies")
+)
+
+// HistoryTimings are in milliseconds like in HAR
+type HistoryTimings struct {
+	// request handler to response headers
+	Wait float64 `json:"wait"`
+	// response headers to the end of the body
+	Receive float64 `json:"receive"`
+	Total   float64 `json:"total"`
+}
+
+// HistoryEntry is one proxied exchange, Status is 0 until the response comes
+type HistoryEntry struct {
+	ID           uint64         `json:"id"`
+	Session      int64          `json:"session"`
+	Time         time.Time      `json:"time"`
+	Method       string         `json:"method"`
+	URL          string         `json:"url"`
+	Host         string         `json:"host"`
+	Path         string         `json:"path"`
+	Status       int            `json:"status"`
+	Mime         string         `json:"mime"`
+	RequestSize  int64          `json:"request_size"`
+	ResponseSize int64          `json:"response_size"`
+	FromCache    bool           `json:"from_cache"`
+	CacheMode    CacheMode      `json:"cache_mode"`
+	Hash         string         `json:"hash"`
+	Error        string         `json:"error,omitempty"`
+	Timings      HistoryTimings `json:"timings"`
+	// id of the entry this one is repeated from
+	RepeatOf uint64 `json:"repeat_of,omitempty"`
+	// original values, relax policies may rewrite them
+	CSP           []string `json:"csp,omitempty"`
+	CSPReportOnly []string `json:"csp_report_only,omitempty"`
+	XSSProtection string   `json:"x_xss_protection,omitempty"`
+	// ids of the match and replace rules applied
+	Replaced []string `json:"replaced,omitempty"`
+	// file served instead of the url
+	MapLocal string `json:"map_local,omitempty"`
+	// url before a map remote rule rewrote it
+	MapRemote string `json:"map_remote,omitempty"`
+	// body went to the client as it came, see stream.go
+	Streamed bool `json:"streamed,omitempty"`
+	// recording stopped at the record limit, the body is not cached
+	Truncated bool `json:"truncated,omitempty"`
+	// server sent events recorded
+	Events int `json:"events,omitempty"`
+}
+
+// HistoryFilter zero fields match everything
+type HistoryFilter struct {
+	Method string
+	Host   string
+	// path regexp
+	Path   string
+	Status int
+	From   time.Time
+	To     time.Time
+	Offset int
+	// 0 is no limit
+	Limit int
+
+	path *regexp.Regexp
+}
+
+func (f *HistoryFilter) Match(e *HistoryEntry) bool {
+	return (f.Method == "" || strings.EqualFold(f.Method, e.Method)) &&
+		(f.Host == "" || strings.EqualFold(f.Host, e.Host)) &&
+		(f.path == nil || f.path.MatchString(e.Path)) &&
+		(f.Status == 0 || f.Status == e.Status) &&
+		(f.From.IsZero() || !e.Time.Before(f.From)) &&
+		(f.To.IsZero() || e.Time.Before(f.To))
+}
+
+// queued writes, Add and Update block only when the writer is this far behind
+const historyQueueSize = 4096
+
+// History keeps every exchange in a bbolt file under sequential ids.
+// Ids are given right away, writes are queued and committed in batches by one goroutine
+// so proxied requests don't wait for the disk. Reads flush the queue first.
+// Entries are changed under mux as handlers and body readers share them.
+type History struct {
+	db     *bolt.DB
+	mux    *sync.Mutex
+	seq    uint64
+	writes chan *historyWrite
+	closed chan struct{}
+}
+
+// historyWrite stores the entry as it is when written, the request only after Add.
+// A write without an entry is a flush, flushed is closed once the writes before it are committed.
+type historyWrite struct {
+	e       *HistoryEntry
+	req     []byte
+	body    []byte
+	flushed chan struct{}
+}
+
+func NewHistory(filename string) (*History, error) {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "open %s", filename)
+	}
+	var seq uint64
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltHistory, boltHistoryRequests, boltHistoryRequestBodies} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		seq = tx.Bucket(boltHistory).Sequence()
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.WithStack(err)
+	}
+	h := &History{
+		db:     db,
+		mux:    &sync.Mutex{},
+		seq:    seq,
+		writes: make(chan *historyWrite, historyQueueSize),
+		closed: make(chan struct{}),
+	}
+	go h.writer()
+	return h, nil
+}
+
+// Close commits the queued writes, nothing may be added after it
+func (h *History) Close() error {
+	close(h.writes)
+	<-h.closed
+	return h.db.Close()
+}
+
+// writer commits everything queued by the time the previous commit is done in one transaction
+func (h *History) writer() {
+	defer close(h.closed)
+	for w := range h.writes {
+		batch := []*historyWrite{w}
+	drain:
+		for len(batch) < historyQueueSize {
+			select {
+			case w, ok := <-h.writes:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, w)
+			default:
+				break drain
+			}
+		}
+		if err := h.commit(batch); err != nil {
+			logrus.WithError(err).Error("history write")
+		}
+		for _, w := range batch {
+			if w.flushed != nil {
+				close(w.flushed)
+			}
+		}
+	}
+}
+
+func (h *History) commit(batch []*historyWrite) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltHistory)
+		seq := b.Sequence()
+		for _, w := range batch {
+			if w.e == nil {
+				continue
+			}
+			h.mux.Lock()
+			id := w.e.ID
+			data, err := json.Marshal(w.e)
+			h.mux.Unlock()
+			if err != nil {
+				return err
+			}
+			if err = b.Put(historyKey(id), data); err != nil {
+				return err
+			}
+			if id > seq {
+				seq = id
+			}
+			if w.req == nil {
+				continue
+			}
+			if err = tx.Bucket(boltHistoryRequests).Put(historyKey(id), w.req); err != nil {
+				return err
+			}
+			if err = tx.Bucket(boltHistoryRequestBodies).Put(historyKey(id), w.body); err != nil {
+				return err
+			}
+		}
+		return b.SetSequence(seq)
+	})
+}
+
+// flush waits for the writes queued before it
+func (h *History) flush() {
+	w := &historyWrite{flushed: make(chan struct{})}
+	h.writes <- w
+	<-w.flushed
+}
+
+// Add gives e the next id and queues it with its request
+func (h *History) Add(e *HistoryEntry, req *RequestDTO) error {
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	h.mux.Lock()
+	h.seq++
+	e.ID = h.seq
+	h.mux.Unlock()
+	h.writes <- &historyWrite{e: e, req: reqData, body: req.body}
+	return nil
+}
+
+// Update changes e with update and queues it to overwrite the stored entry.
+// It is marshaled when written, the last write has the latest changes.
+func (h *History) Update(e *HistoryEntry, update func(e *HistoryEntry)) error {
+	h.mux.Lock()
+	update(e)
+	h.mux.Unlock()
+	h.writes <- &historyWrite{e: e}
+	return nil
+}
+
+func (h *History) Get(id uint64) (*HistoryEntry, error) {
+	h.flush()
+	e := &HistoryEntry{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltHistory).Get(historyKey(id))
+		if data == nil {
+			return errors.Errorf("history entry %d not found", id)
+		}
+		return json.Unmarshal(data, e)
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return e, nil
+}
+
+// Request loads the request stored by Add
+func (h *History) Request(id uint64) (*RequestDTO, error) {
+	h.flush()
+	var req *RequestDTO
+	err := h.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltHistoryRequests).Get(historyKey(id))
+		if data == nil {
+			return errors.Errorf("history request %d not found", id)
+		}
+		// bolt values are valid only during the transaction
+		req = &RequestDTO{body: append([]byte{}, tx.Bucket(boltHistoryRequestBodies).Get(historyKey(id))...)}
+		return json.Unmarshal(data, req)
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return req, nil
+}
+
+// historyMatch are the fields filters look at, cheaper to decode than the whole entry
+type historyMatch struct {
+	Method string    `json:"method"`
+	Host   string    `json:"host"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+	Time   time.Time `json:"time"`
+}
+
+// Find returns a page of matching entries, newest first, and whether there are more after it.
+// The scan stops at the first matching entry past the page.
+func (h *History) Find(filter HistoryFilter) ([]*HistoryEntry, bool, error) {
+	if filter.Path != "" {
+		var err error
+		if filter.path, err = regexp.Compile(filter.Path); err != nil {
+			return nil, false, errors.WithStack(err)
+		}
+	}
+	h.flush()
+	res := make([]*HistoryEntry, 0)
+	more := false
+	err := h.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(boltHistory).Cursor()
+		matched := 0
+		for k, v := cur.Last(); k != nil; k, v = cur.Prev() {
+			m := &historyMatch{}
+			if err := json.Unmarshal(v, m); err != nil {
+				return err
+			}
+			e := &HistoryEntry{Method: m.Method, Host: m.Host, Path: m.Path, Status: m.Status, Time: m.Time}
+			if !filter.Match(e) {
+				continue
+			}
+			matched++
+			if matched <= filter.Offset {
+				continue
+			}
+			if filter.Limit > 0 && len(res) == filter.Limit {
+				more = true
+				return nil
+			}
+			if err := json.Unmarshal(v, e); err != nil {
+				return err
+			}
+			res = append(res, e)
+		}
+		return nil
+	})
+	return res, more, errors.WithStack(err)
+}
+
+// OnRequest adds the entry before the response is known,
+// it is changed only through Update afterwards
+func (h *History) OnRequest(e *HistoryEntry, req *RequestDTO) {
+	if err := h.Add(e, req); err != nil {
+		logrus.WithError(err).Error("history add")
+	}
+}
+
+func NewHistoryEntry(req *RequestDTO, session int64) *HistoryEntry {
+	return &HistoryEntry{
+		Session:     session,
+		Time:        time.Now(),
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		Host:        req.URL.Host,
+		Path:        req.URL.Path,
+		RequestSize: int64(len(req.body)),
+		CacheMode:   req.cacheMode,
+		Hash:        req.Hash(),
+	}
+}
+
+// OnResponse fills the entry, size and timings are known when the body is closed
+func (h *History) OnResponse(req *RequestDTO, resp *http.Response, ctxErr error) {
+	e := req.history
+	if e == nil {
+		return
+	}
+	if resp == nil {
+		h.update(e, func(e *HistoryEntry) {
+			e.FromCache, e.CacheMode = req.fromCache, req.cacheMode
+			e.Timings.Wait = milliseconds(time.Since(e.Time))
+			if ctxErr != nil {
+				e.Error = ctxErr.Error()
+			}
+			e.Timings.Total = e.Timings.Wait
+		})
+		return
+	}
+	done := resp.Body == nil || resp.StatusCode == http.StatusSwitchingProtocols
+	h.update(e, func(e *HistoryEntry) {
+		e.FromCache, e.CacheMode = req.fromCache, req.cacheMode
+		e.Timings.Wait = milliseconds(time.Since(e.Time))
+		e.Status = resp.StatusCode
+		e.Mime, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		e.CSP = resp.Header.Values("Content-Security-Policy")
+		e.CSPReportOnly = resp.Header.Values("Content-Security-Policy-Report-Only")
+		e.XSSProtection = resp.Header.Get("X-XSS-Protection")
+		if done {
+			e.Timings.Total = e.Timings.Wait
+		}
+	})
+	if done {
+		return
+	}
+	resp.Body = &historyBody{ReadCloser: resp.Body, once: &sync.Once{}, done: func(n int64) {
+		h.update(e, func(e *HistoryEntry) {
+			e.ResponseSize = n
+			e.Timings.Total = milliseconds(time.Since(e.Time))
+			e.Timings.Receive = e.Timings.Total - e.Timings.Wait
+		})
+	}}
+}
+
+// update is Update logging the error
+func (h *History) update(e *HistoryEntry, update func(e *HistoryEntry)) {
+	if err := h.Update(e, update); err != nil {
+		logrus.WithError(err).WithField("id", e.ID).Error("history put")
+	}
+}
+
+// historyBody counts bytes read by the client side
+type historyBody struct {
+	io.ReadCloser
+	n    int64
+	once *sync.Once
+	done func(n int64)
+}
+
+func (b *historyBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b.n) })
+	}
+	return n, err
+}
+
+func (b *historyBody) Close() error {
+	b.once.Do(func() { b.done(b.n) })
+	return b.ReadCloser.Close()
+}
+
+func historyKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
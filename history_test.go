@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newTestHistory(t *testing.T) *History {
+	dir, err := ioutil.TempDir("", "anothergoproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHistory(filepath.Join(dir, "history.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		h.Close()
+		os.RemoveAll(dir)
+	})
+	return h
+}
+
+func TestHistoryFind(t *testing.T) {
+	h := newTestHistory(t)
+	for i := 1; i <= 10; i++ {
+		host := "a.com"
+		if i%2 == 0 {
+			host = "b.com"
+		}
+		req := newTestRequest("GET", fmt.Sprintf("http://%s/%d", host, i), "", "")
+		if err := h.Add(NewHistoryEntry(req, int64(i)), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter HistoryFilter
+		ids    []uint64
+		more   bool
+	}{
+		{"all", HistoryFilter{}, []uint64{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, false},
+		{"first page", HistoryFilter{Limit: 3}, []uint64{10, 9, 8}, true},
+		{"last page", HistoryFilter{Offset: 8, Limit: 3}, []uint64{2, 1}, false},
+		{"exact page", HistoryFilter{Offset: 7, Limit: 3}, []uint64{3, 2, 1}, false},
+		{"host", HistoryFilter{Host: "A.com", Limit: 2}, []uint64{9, 7}, true},
+		{"host last page", HistoryFilter{Host: "b.com", Offset: 3, Limit: 2}, []uint64{4, 2}, false},
+		{"path", HistoryFilter{Path: "^/1"}, []uint64{10, 1}, false},
+		{"nothing", HistoryFilter{Method: "POST"}, []uint64{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, more, err := h.Find(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]uint64, 0)
+			for _, e := range entries {
+				ids = append(ids, e.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.ids) || more != tt.more {
+				t.Errorf("ids %v more %v, want %v %v", ids, more, tt.ids, tt.more)
+			}
+		})
+	}
+}
+
+func TestHistoryConcurrentUpdates(t *testing.T) {
+	h := newTestHistory(t)
+	req := newTestRequest("GET", "http://example.com/", "", "")
+	e := NewHistoryEntry(req, 1)
+	if err := h.Add(e, req); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			h.update(e, func(e *HistoryEntry) { e.Replaced = append(e.Replaced, fmt.Sprint(i)) })
+		}(i)
+	}
+	wg.Wait()
+	stored, err := h.Get(e.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Replaced) != 20 {
+		t.Errorf("stored entry has %d of 20 updates", len(stored.Replaced))
+	}
+}
+
+func TestHistoryReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anothergoproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "history.db")
+
+	ids := []uint64{}
+	for i := 0; i < 2; i++ {
+		h, err := NewHistory(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := newTestRequest("POST", "http://example.com/", "text/plain", fmt.Sprint(i))
+		e := NewHistoryEntry(req, int64(i))
+		if err := h.Add(e, req); err != nil {
+			t.Fatal(err)
+		}
+		h.update(e, func(e *HistoryEntry) { e.Status = 200 })
+		ids = append(ids, e.ID)
+		// queued writes are committed by Close
+		if err := h.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("ids %v, want [1 2]", ids)
+	}
+
+	h, err := NewHistory(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	for i, id := range ids {
+		e, err := h.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Status != 200 {
+			t.Errorf("entry %d status %d, want 200", id, e.Status)
+		}
+		req, err := h.Request(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(req.body) != fmt.Sprint(i) {
+			t.Errorf("entry %d body %q, want %q", id, req.body, fmt.Sprint(i))
+		}
+	}
+}
+
+func TestHistoryFindMITMURL(t *testing.T) {
+	h := newTestHistory(t)
+	req := mitmRequest("GET", "https://Example.com:443/a")
+	e := NewHistoryEntry(req, 1)
+	if err := h.Add(e, req); err != nil {
+		t.Fatal(err)
+	}
+	entries, _, err := h.Find(HistoryFilter{Host: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].URL != "https://example.com/a" {
+		t.Errorf("host example.com doesn't find the MITM'd request: %+v", entries)
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type InterceptAction string
+
+const (
+	InterceptForward InterceptAction = "forward"
+	InterceptDrop    InterceptAction = "drop"
+)
+
+const (
+	InterceptRequest  = "request"
+	InterceptResponse = "response"
+)
+
+const interceptDefaultTimeout = 60
+
+type InterceptConfig struct {
+	Requests  bool `json:"requests"`
+	Responses bool `json:"responses"`
+	// same rules as the global scope, empty is everything
+	Scope []*ScopeRule `json:"scope,omitempty"`
+	// seconds an item is held, forgotten ones don't hang the browser
+	Timeout       int             `json:"timeout"`
+	TimeoutAction InterceptAction `json:"timeout_action"`
+
+	scope *Scope
+}
+
+// InterceptItem is a held request or response, Raw is what is forwarded unless edited
+type InterceptItem struct {
+	ID       uint64    `json:"id"`
+	Kind     string    `json:"kind"`
+	Session  int64     `json:"session"`
+	Time     time.Time `json:"time"`
+	Deadline time.Time `json:"deadline"`
+	Method   string    `json:"method"`
+	URL      string    `json:"url"`
+	Status   int       `json:"status,omitempty"`
+	Raw      string    `json:"raw"`
+
+	decision chan interceptDecision
+}
+
+type interceptDecision struct {
+	action InterceptAction
+	// edited raw message, empty is unchanged
+	raw string
+}
+
+// Interceptor holds matching traffic until it is forwarded or dropped through the api
+type Interceptor struct {
+	mux         *sync.Mutex
+	config      InterceptConfig
+	items       map[uint64]*InterceptItem
+	lastID      uint64
+	subscribers map[chan *InterceptItem]bool
+}
+
+func NewInterceptor() *Interceptor {
+	i := &Interceptor{
+		mux:         &sync.Mutex{},
+		items:       make(map[uint64]*InterceptItem),
+		subscribers: make(map[chan *InterceptItem]bool),
+	}
+	if err := i.SetConfig(InterceptConfig{}); err != nil {
+		logrus.WithError(err).Error("intercept config")
+	}
+	return i
+}
+
+func (i *Interceptor) Config() InterceptConfig {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	return i.config
+}
+
+// SetConfig forwards held items of a kind which is not intercepted anymore
+func (i *Interceptor) SetConfig(c InterceptConfig) error {
+	if c.Timeout <= 0 {
+		c.Timeout = interceptDefaultTimeout
+	}
+	switch c.TimeoutAction {
+	case "":
+		c.TimeoutAction = InterceptForward
+	case InterceptForward, InterceptDrop:
+	default:
+		return errors.Errorf("unknown timeout action %q", c.TimeoutAction)
+	}
+	c.scope = &Scope{mux: &sync.RWMutex{}}
+	if err := c.scope.set(c.Scope); err != nil {
+		return errors.WithStack(err)
+	}
+	i.mux.Lock()
+	i.config = c
+	released := make([]*InterceptItem, 0)
+	for id, item := range i.items {
+		if item.Kind == InterceptRequest && !c.Requests || item.Kind == InterceptResponse && !c.Responses {
+			delete(i.items, id)
+			released = append(released, item)
+		}
+	}
+	i.mux.Unlock()
+	for _, item := range released {
+		item.decision <- interceptDecision{action: InterceptForward}
+	}
+	return nil
+}
+
+// Queue returns held items, oldest first
+func (i *Interceptor) Queue() []*InterceptItem {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	res := make([]*InterceptItem, 0, len(i.items))
+	for _, item := range i.items {
+		res = append(res, item)
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].ID < res[b].ID })
+	return res
+}
+
+func (i *Interceptor) Get(id uint64) (*InterceptItem, bool) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+	item, ok := i.items[id]
+	return item, ok
+}
+
+// Decide releases a held item, raw replaces the message when forwarded
+func (i *Interceptor) Decide(id uint64, action InterceptAction, raw string) error {
+	if action != InterceptForward && action != InterceptDrop {
+		return errors.Errorf("unknown action %q", action)
+	}
+	i.mux.Lock()
+	item, ok := i.items[id]
+	delete(i.items, id)
+	i.mux.Unlock()
+	if !ok {
+		return errors.Errorf("no held item %d", id)
+	}
+	item.decision <- interceptDecision{action: action, raw: raw}
+	return nil
+}
+
+// Subscribe streams newly held items until cancel is called
+func (i *Interceptor) Subscribe() (<-chan *InterceptItem, func()) {
+	ch := make(chan *InterceptItem, 16)
+	i.mux.Lock()
+	i.subscribers[ch] = true
+	i.mux.Unlock()
+	return ch, func() {
+		i.mux.Lock()
+		delete(i.subscribers, ch)
+		i.mux.Unlock()
+	}
+}
+
+func (i *Interceptor) match(kind string, req *http.Request) (InterceptConfig, bool) {
+	i.mux.Lock()
+	c := i.config
+	i.mux.Unlock()
+	if kind == InterceptRequest && !c.Requests || kind == InterceptResponse && !c.Responses {
+		return c, false
+	}
+	return c, c.scope.InScope(req.Method, req.URL)
+}
+
+// hold blocks until the item is decided or its deadline
+func (i *Interceptor) hold(item *InterceptItem, c InterceptConfig) interceptDecision {
+	timeout := time.Duration(c.Timeout) * time.Second
+	item.Time = time.Now()
+	item.Deadline = item.Time.Add(timeout)
+	item.decision = make(chan interceptDecision, 1)
+
+	i.mux.Lock()
+	i.lastID++
+	item.ID = i.lastID
+	i.items[item.ID] = item
+	for ch := range i.subscribers {
+		select {
+		case ch <- item:
+		default:
+			// slow subscriber, it still sees the item in the queue
+		}
+	}
+	i.mux.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case d := <-item.decision:
+		return d
+	case <-timer.C:
+	}
+	i.mux.Lock()
+	_, pending := i.items[item.ID]
+	delete(i.items, item.ID)
+	i.mux.Unlock()
+	if !pending {
+		// decided right at the deadline
+		return <-item.decision
+	}
+	logrus.Printf("[%d] intercept %s %s timed out, %s", item.Session, item.Kind, urlColor(item.URL), c.TimeoutAction)
+	return interceptDecision{action: c.TimeoutAction}
+}
+
+// Request holds req if it matches, a non nil response means it was dropped
+func (i *Interceptor) Request(req *http.Request, session int64) *http.Response {
+	c, ok := i.match(InterceptRequest, req)
+	if !ok {
+		return nil
+	}
+	body, err := readBody(&req.Body)
+	if err != nil {
+		logrus.WithError(err).Error("intercept: read request body")
+		return nil
+	}
+	setRequestBody(req, body)
+	// restores the body
+	raw, err := httputil.DumpRequest(req, true)
+	if err != nil {
+		logrus.WithError(err).Error("intercept: dump request")
+		return nil
+	}
+	item := &InterceptItem{Kind: InterceptRequest, Session: session, Method: req.Method, URL: req.URL.String(), Raw: string(raw)}
+	d := i.hold(item, c)
+	if d.action == InterceptDrop {
+		return goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusBadGateway, "anothergoproxy: request dropped by intercept")
+	}
+	if d.raw == "" {
+		return nil
+	}
+	e, err := parseEditedRequest(d.raw, req.URL.Scheme)
+	if err != nil {
+		logrus.WithError(err).WithField("id", item.ID).Error("intercept: edited request, forwarded unchanged")
+		return nil
+	}
+	req.Method, req.URL, req.Host, req.Header = e.Method, e.URL, e.Host, e.Header
+	req.Body, req.ContentLength, req.TransferEncoding = e.Body, e.ContentLength, e.TransferEncoding
+	return nil
+}
+
+// Response holds resp if it matches, the body is decoded to be editable as text.
+// Event streams are not held, they never end.
+func (i *Interceptor) Response(req *http.Request, resp *http.Response, session int64) *http.Response {
+	c, ok := i.match(InterceptResponse, req)
+	if !ok || resp.StatusCode == http.StatusSwitchingProtocols || isEventStream(resp.Header) {
+		return resp
+	}
+	if resp.Body != nil {
+		body, err := readBody(&resp.Body)
+		if err != nil {
+			logrus.WithError(err).Error("intercept: read response body")
+			return resp
+		}
+		if decoded, err := decodeBody(resp.Header.Get("Content-Encoding"), body); err == nil {
+			resp.Header.Del("Content-Encoding")
+			body = decoded
+		}
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		resp.ContentLength, resp.TransferEncoding = int64(len(body)), nil
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	raw, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		logrus.WithError(err).Error("intercept: dump response")
+		return resp
+	}
+	item := &InterceptItem{Kind: InterceptResponse, Session: session, Method: req.Method, URL: req.URL.String(), Status: resp.StatusCode, Raw: string(raw)}
+	d := i.hold(item, c)
+	if d.action == InterceptDrop {
+		return goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusBadGateway, "anothergoproxy: response dropped by intercept")
+	}
+	if d.raw == "" {
+		return resp
+	}
+	edited, err := ParseRawResponse(d.raw, req)
+	if err != nil {
+		logrus.WithError(err).WithField("id", item.ID).Error("intercept: edited response, forwarded unchanged")
+		return resp
+	}
+	return edited
+}
+
+// ParseRawResponse reads a pasted response, Content-Length is fixed up after edits
+func ParseRawResponse(raw string, req *http.Request) (*http.Response, error) {
+	head, body := splitRawMessage(raw)
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(head)), req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.ContentLength, resp.TransferEncoding = int64(len(body)), nil
+	resp.Body = ioutil.NopCloser(strings.NewReader(body))
+	return resp, nil
+}
+
+func parseEditedRequest(raw, scheme string) (*http.Request, error) {
+	head, body := splitRawMessage(raw)
+	req, err := ParseRawRequest(head, scheme)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	setRequestBody(req.Request, []byte(body))
+	if body != "" {
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return req.Request, nil
+}
+
+// splitRawMessage drops framing headers, edits change the body length
+func splitRawMessage(raw string) (string, string) {
+	if !strings.Contains(raw, "\r\n") {
+		raw = strings.Replace(raw, "\n", "\r\n", -1)
+	}
+	head, body := raw, ""
+	if i := strings.Index(raw, "\r\n\r\n"); i >= 0 {
+		head, body = raw[:i], raw[i+4:]
+	}
+	lines := strings.Split(head, "\r\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		name := strings.ToLower(strings.TrimSpace(strings.SplitN(line, ":", 2)[0]))
+		if name == "content-length" || name == "transfer-encoding" {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\r\n") + "\r\n\r\n", body
+}
+
+func (c *CacheHandlers) interceptHandler(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+	if resp == nil || ctx.Req == nil {
+		return resp
+	}
+	return c.interceptor.Response(ctx.Req, resp, ctx.Session)
+}
@@ -1,6 +1,56 @@
 package main
 
+import "time"
+
 type ReqRespCacheI interface {
 	Load(*RequestDTO) (*ResponseDTO, error)
 	Store(*RequestDTO, *ResponseDTO) error
+	// LoadHash loads response stored under the request hash
+	LoadHash(hash string) (*ResponseDTO, error)
+	// Similar returns stored requests with the same method, host and path by hash
+	Similar(*RequestDTO) (map[string]*RequestDTO, error)
+	// StoreEntry stores under entry.Hash, used to copy entries between caches
+	StoreEntry(*CacheEntry, *RequestDTO, *ResponseDTO) error
+	LoadEntry(hash string) (*RequestDTO, *ResponseDTO, error)
+	Find(CacheFilter) ([]*CacheEntry, error)
+	Close() error
+}
+
+type CacheEntry struct {
+	Hash   string    `json:"hash"`
+	Method string    `json:"method"`
+	Host   string    `json:"host"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+	Time   time.Time `json:"time"`
+}
+
+// CacheFilter zero fields match everything
+type CacheFilter struct {
+	Method string
+	Host   string
+	Path   string
+	Status int
+	From   time.Time
+	To     time.Time
+}
+
+func NewCacheEntry(hash string, req *RequestDTO, resp *ResponseDTO, t time.Time) *CacheEntry {
+	return &CacheEntry{
+		Hash:   hash,
+		Method: req.Method,
+		Host:   req.URL.Host,
+		Path:   req.URL.Path,
+		Status: resp.StatusCode,
+		Time:   t,
+	}
+}
+
+func (f CacheFilter) Match(e *CacheEntry) bool {
+	return (f.Method == "" || f.Method == e.Method) &&
+		(f.Host == "" || f.Host == e.Host) &&
+		(f.Path == "" || f.Path == e.Path) &&
+		(f.Status == 0 || f.Status == e.Status) &&
+		(f.From.IsZero() || !e.Time.Before(f.From)) &&
+		(f.To.IsZero() || e.Time.Before(f.To))
 }
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/sjson"
+)
+
+type IntruderMode string
+
+const (
+	// one payload set, each position in turn, the others keep original values
+	IntruderSniper IntruderMode = "sniper"
+	// one payload set, the same payload in every position
+	IntruderBatteringRam IntruderMode = "battering-ram"
+	// a payload set per position, iterated together
+	IntruderPitchfork IntruderMode = "pitchfork"
+	// a payload set per position, every combination
+	IntruderClusterBomb IntruderMode = "cluster-bomb"
+)
+
+// intruderMarker wraps raw positions in IntruderAttack.Raw like §value§
+const intruderMarker = "§"
+
+const (
+	intruderMaxConcurrency = 64
+	// finished runs kept in memory, results of older ones are only in their jsonl file
+	intruderKeepRuns = 20
+)
+
+type IntruderPosition struct {
+	// query, form, json, header or raw
+	Kind string `json:"kind"`
+	// param or header name, dot separated json path
+	Name string `json:"name"`
+	// raw byte offsets [start, end) in the raw request without markers
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type IntruderAttack struct {
+	// history id of the base request
+	ID uint64 `json:"id"`
+	// or raw base request, §markers§ add raw positions
+	Raw string `json:"raw"`
+	// for raw origin-form requests, https by default
+	Scheme      string             `json:"scheme"`
+	Positions   []IntruderPosition `json:"positions"`
+	Mode        IntruderMode       `json:"mode"`
+	Payloads    [][]string         `json:"payloads"`
+	Concurrency int                `json:"concurrency"`
+	// requests per second, 0 is unlimited
+	Rate float64 `json:"rate"`
+	// response regexps, matches are in IntruderResult.Grep
+	Grep []string `json:"grep"`
+
+	grep []*regexp.Regexp
+}
+
+type IntruderResult struct {
+	Index int `json:"index"`
+	// by position, null is the original value (sniper)
+	Payloads []*string `json:"payloads"`
+	// history id of the request sent
+	HistoryID uint64         `json:"history_id"`
+	Status    int            `json:"status"`
+	Length    int            `json:"length"`
+	Timings   HistoryTimings `json:"timings"`
+	Grep      []string       `json:"grep"`
+	Error     string         `json:"error,omitempty"`
+}
+
+type IntruderRun struct {
+	mux     *sync.Mutex
+	ID      string            `json:"id"`
+	Attack  *IntruderAttack   `json:"attack"`
+	Started time.Time         `json:"started"`
+	Ended   time.Time         `json:"ended"`
+	Total   int               `json:"total"`
+	Done    int               `json:"done"`
+	State   string            `json:"state"`
+	Results []*IntruderResult `json:"results,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// Snapshot copies the run, results are left out unless withResults
+func (r *IntruderRun) Snapshot(withResults bool) *IntruderRun {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	res := *r
+	res.Results = nil
+	if withResults {
+		res.Results = append([]*IntruderResult{}, r.Results...)
+	}
+	return &res
+}
+
+// Intruder runs attacks through the repeater, every attempt is a history entry
+type Intruder struct {
+	mux      *sync.Mutex
+	runs     map[string]*IntruderRun
+	repeater *Repeater
+	history  *History
+	path     string
+}
+
+func NewIntruder(repeater *Repeater, history *History, path string) *Intruder {
+	return &Intruder{
+		mux:      &sync.Mutex{},
+		runs:     make(map[string]*IntruderRun),
+		repeater: repeater,
+		history:  history,
+		path:     path,
+	}
+}
+
+func (in *Intruder) Runs() []*IntruderRun {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+	res := make([]*IntruderRun, 0, len(in.runs))
+	for _, run := range in.runs {
+		res = append(res, run.Snapshot(false))
+	}
+	return res
+}
+
+func (in *Intruder) Run(id string) (*IntruderRun, bool) {
+	in.mux.Lock()
+	defer in.mux.Unlock()
+	run, ok := in.runs[id]
+	return run, ok
+}
+
+func (in *Intruder) Cancel(id string) bool {
+	run, ok := in.Run(id)
+	if ok {
+		run.cancel()
+	}
+	return ok
+}
+
+// Start validates the attack and runs it in background
+func (in *Intruder) Start(attack *IntruderAttack) (*IntruderRun, error) {
+	base, err := in.baseRequest(attack)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = attack.checkRawPositions(base); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	combos, err := attack.Combinations()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if max := options.IntruderMaxAttempts; max > 0 && combos.Len() > max {
+		return nil, errors.Errorf("%d attempts, more than --intruder-max-attempts %d", combos.Len(), max)
+	}
+	for _, s := range attack.Grep {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		attack.grep = append(attack.grep, re)
+	}
+	if attack.Concurrency < 1 {
+		attack.Concurrency = 1
+	}
+	if attack.Concurrency > intruderMaxConcurrency {
+		attack.Concurrency = intruderMaxConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &IntruderRun{
+		mux:     &sync.Mutex{},
+		ID:      fmt.Sprintf("%d", time.Now().UnixNano()),
+		Attack:  attack,
+		Started: time.Now(),
+		Total:   combos.Len(),
+		State:   "running",
+		Results: make([]*IntruderResult, 0),
+		cancel:  cancel,
+	}
+	in.mux.Lock()
+	in.prune()
+	in.runs[run.ID] = run
+	in.mux.Unlock()
+
+	go in.run(ctx, run, base, combos)
+	return run, nil
+}
+
+// prune drops the oldest finished runs past intruderKeepRuns, in.mux is held
+func (in *Intruder) prune() {
+	finished := make([]*IntruderRun, 0)
+	for _, run := range in.runs {
+		run.mux.Lock()
+		if !run.Ended.IsZero() {
+			finished = append(finished, run)
+		}
+		run.mux.Unlock()
+	}
+	if len(finished) <= intruderKeepRuns {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].Started.Before(finished[j].Started) })
+	for _, run := range finished[:len(finished)-intruderKeepRuns] {
+		delete(in.runs, run.ID)
+	}
+}
+
+func (in *Intruder) baseRequest(attack *IntruderAttack) (string, error) {
+	if attack.Raw != "" {
+		if attack.Scheme == "" {
+			attack.Scheme = "https"
+		}
+		raw, positions := parseIntruderMarkers(attack.Raw)
+		attack.Positions = append(positions, attack.Positions...)
+		return raw, nil
+	}
+	req, err := in.history.Request(attack.ID)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	attack.Scheme = req.URL.Scheme
+	raw := req.RawString()
+	if raw == "" {
+		return "", errors.Errorf("can't dump request %d", attack.ID)
+	}
+	return raw, nil
+}
+
+func (in *Intruder) run(ctx context.Context, run *IntruderRun, base string, combos *IntruderCombinations) {
+	defer run.cancel()
+	var out *os.File
+	if in.path != "" {
+		var err error
+		out, err = os.Create(filepath.Join(in.path, run.ID+".jsonl"))
+		if err != nil {
+			logrus.WithError(err).Error("intruder results file")
+		} else {
+			defer out.Close()
+		}
+	}
+
+	var tick <-chan time.Time
+	if run.Attack.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / run.Attack.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < run.Attack.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				res := in.attempt(run.Attack, base, i, combos.At(i))
+				run.mux.Lock()
+				run.Results = append(run.Results, res)
+				run.Done++
+				if out != nil {
+					data, _ := json.Marshal(res)
+					out.Write(append(data, '\n'))
+				}
+				run.mux.Unlock()
+			}
+		}()
+	}
+	state := "done"
+loop:
+	for i := 0; i < combos.Len(); i++ {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				state = "canceled"
+				break loop
+			}
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			state = "canceled"
+			break loop
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	run.mux.Lock()
+	run.State = state
+	run.Ended = time.Now()
+	run.mux.Unlock()
+	logrus.Printf("intruder %s %s: %d of %d", run.ID, state, run.Done, run.Total)
+}
+
+func (in *Intruder) attempt(attack *IntruderAttack, base string, index int, payloads []string) *IntruderResult {
+	res := &IntruderResult{Index: index, Payloads: make([]*string, len(payloads)), Grep: make([]string, 0)}
+	for i := range payloads {
+		if payloads[i] != intruderKeep {
+			res.Payloads[i] = &payloads[i]
+		}
+	}
+	req, err := attack.Request(base, payloads)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	rep, err := in.repeater.Repeat(req, attack.ID)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.HistoryID, res.Timings, res.Error = rep.ID, rep.Timings, rep.Error
+	if rep.Response == nil {
+		return res
+	}
+	res.Status = rep.Response.StatusCode
+	res.Length = len(rep.Response.body)
+	for _, re := range attack.grep {
+		if re.Match(rep.Response.body) {
+			res.Grep = append(res.Grep, re.String())
+		}
+	}
+	return res
+}
+
+// IntruderCombinations maps an attempt index to its payloads, nothing is built in advance
+type IntruderCombinations struct {
+	mode IntruderMode
+	// positions
+	n    int
+	sets [][]string
+	len  int
+}
+
+// Combinations checks payload sets against the mode, attempts are taken with At
+func (a *IntruderAttack) Combinations() (*IntruderCombinations, error) {
+	n := len(a.Positions)
+	if n == 0 {
+		return nil, errors.New("no positions")
+	}
+	if len(a.Payloads) == 0 {
+		return nil, errors.New("no payloads")
+	}
+	c := &IntruderCombinations{mode: a.Mode, n: n, sets: a.Payloads}
+	switch a.Mode {
+	case IntruderSniper, "":
+		c.mode = IntruderSniper
+		c.len = n * len(a.Payloads[0])
+	case IntruderBatteringRam:
+		c.len = len(a.Payloads[0])
+	case IntruderPitchfork:
+		if len(a.Payloads) != n {
+			return nil, errors.Errorf("%s needs %d payload sets, one per position", a.Mode, n)
+		}
+		c.len = len(a.Payloads[0])
+		for _, set := range a.Payloads {
+			if len(set) < c.len {
+				c.len = len(set)
+			}
+		}
+	case IntruderClusterBomb:
+		if len(a.Payloads) != n {
+			return nil, errors.Errorf("%s needs %d payload sets, one per position", a.Mode, n)
+		}
+		c.len = 1
+		for _, set := range a.Payloads {
+			if len(set) == 0 {
+				c.len = 0
+				break
+			}
+			if c.len > math.MaxInt32/len(set) {
+				return nil, errors.Errorf("%s of more than %d attempts", a.Mode, math.MaxInt32)
+			}
+			c.len *= len(set)
+		}
+	default:
+		return nil, errors.Errorf("unknown intruder mode %q", a.Mode)
+	}
+	return c, nil
+}
+
+// Len is the number of attempts
+func (c *IntruderCombinations) Len() int {
+	return c.len
+}
+
+// At returns payloads of attempt i by position.
+// Sniper goes through the set for each position in turn, cluster-bomb changes the last position first.
+func (c *IntruderCombinations) At(i int) []string {
+	combo := make([]string, c.n)
+	switch c.mode {
+	case IntruderSniper:
+		set := c.sets[0]
+		for pos := range combo {
+			combo[pos] = intruderKeep
+		}
+		combo[i/len(set)] = set[i%len(set)]
+	case IntruderBatteringRam:
+		for pos := range combo {
+			combo[pos] = c.sets[0][i]
+		}
+	case IntruderPitchfork:
+		for pos, set := range c.sets {
+			combo[pos] = set[i]
+		}
+	case IntruderClusterBomb:
+		for pos := c.n - 1; pos >= 0; pos-- {
+			set := c.sets[pos]
+			combo[pos] = set[i%len(set)]
+			i /= len(set)
+		}
+	}
+	return combo
+}
+
+// intruderKeep in a combination leaves the position untouched (sniper)
+const intruderKeep = "\x00keep"
+
+// rawPositions are the indexes of raw positions ordered by offset, payloads keep the order of Positions
+func (a *IntruderAttack) rawPositions() []int {
+	res := make([]int, 0)
+	for i, pos := range a.Positions {
+		if pos.Kind == "raw" {
+			res = append(res, i)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return a.Positions[res[i]].Start < a.Positions[res[j]].Start })
+	return res
+}
+
+// checkRawPositions rejects raw positions out of base or overlapping each other
+func (a *IntruderAttack) checkRawPositions(base string) error {
+	end := 0
+	for _, i := range a.rawPositions() {
+		pos := a.Positions[i]
+		if pos.Start < 0 || pos.End < pos.Start || pos.End > len(base) {
+			return errors.Errorf("raw position %d:%d out of the request", pos.Start, pos.End)
+		}
+		if pos.Start < end {
+			return errors.Errorf("raw position %d:%d overlaps another one", pos.Start, pos.End)
+		}
+		end = pos.End
+	}
+	return nil
+}
+
+// Request puts payloads to positions of the raw base request
+func (a *IntruderAttack) Request(base string, payloads []string) (*RequestDTO, error) {
+	if err := a.checkRawPositions(base); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// raw positions first, from the end so offsets before them stay valid
+	raw := base
+	positions := a.rawPositions()
+	for k := len(positions) - 1; k >= 0; k-- {
+		i := positions[k]
+		if payloads[i] == intruderKeep {
+			continue
+		}
+		pos := a.Positions[i]
+		raw = raw[:pos.Start] + payloads[i] + raw[pos.End:]
+	}
+	req, err := ParseRawRequest(raw, a.Scheme)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	r := req.HttpRequest()
+	body := req.body
+	for i, pos := range a.Positions {
+		p := payloads[i]
+		if p == intruderKeep {
+			continue
+		}
+		switch pos.Kind {
+		case "raw":
+		case "query":
+			r.URL.RawQuery = setPair(r.URL.RawQuery, pos.Name, p)
+		case "form":
+			body = []byte(setPair(string(body), pos.Name, p))
+		case "json":
+			if body, err = sjson.SetBytes(body, pos.Name, p); err != nil {
+				return nil, errors.Wrapf(err, "json path %s", pos.Name)
+			}
+		case "header":
+			if strings.EqualFold(pos.Name, "Host") {
+				r.Host = p
+			} else {
+				r.Header.Set(pos.Name, p)
+			}
+		default:
+			return nil, errors.Errorf("unknown position kind %q", pos.Kind)
+		}
+	}
+	r.Body = http.NoBody
+	if len(body) > 0 {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	r.ContentLength = int64(len(body))
+	r.Header.Del("Content-Length")
+	return NewRequestDTO(r), nil
+}
+
+// setPair replaces value of name in a=1&b=2 keeping the rest as is, appends if missing
+func setPair(raw, name, value string) string {
+	pair := url.QueryEscape(name) + "=" + url.QueryEscape(value)
+	if raw == "" {
+		return pair
+	}
+	pairs := strings.Split(raw, "&")
+	found := false
+	for i, p := range pairs {
+		k := strings.SplitN(p, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(k); err == nil {
+			k = unescaped
+		}
+		if k == name {
+			pairs[i] = pair
+			found = true
+		}
+	}
+	if !found {
+		pairs = append(pairs, pair)
+	}
+	return strings.Join(pairs, "&")
+}
+
+// parseIntruderMarkers strips §markers§ returning their offsets in the result
+func parseIntruderMarkers(raw string) (string, []IntruderPosition) {
+	positions := make([]IntruderPosition, 0)
+	b := &strings.Builder{}
+	start := -1
+	for {
+		i := strings.Index(raw, intruderMarker)
+		if i < 0 {
+			b.WriteString(raw)
+			break
+		}
+		b.WriteString(raw[:i])
+		raw = raw[i+len(intruderMarker):]
+		if start < 0 {
+			start = b.Len()
+		} else {
+			positions = append(positions, IntruderPosition{Kind: "raw", Start: start, End: b.Len()})
+			start = -1
+		}
+	}
+	return b.String(), positions
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIntruderCombinations(t *testing.T) {
+	positions := func(n int) []IntruderPosition {
+		return make([]IntruderPosition, n)
+	}
+	keep := "_"
+	tests := []struct {
+		name     string
+		attack   IntruderAttack
+		combos   []string
+		errorMsg string
+	}{
+		{
+			name:   "sniper",
+			attack: IntruderAttack{Mode: IntruderSniper, Positions: positions(2), Payloads: [][]string{{"a", "b"}}},
+			combos: []string{"a _", "b _", "_ a", "_ b"},
+		},
+		{
+			name:   "default is sniper",
+			attack: IntruderAttack{Positions: positions(1), Payloads: [][]string{{"a", "b"}}},
+			combos: []string{"a", "b"},
+		},
+		{
+			name:   "battering ram",
+			attack: IntruderAttack{Mode: IntruderBatteringRam, Positions: positions(3), Payloads: [][]string{{"a", "b"}}},
+			combos: []string{"a a a", "b b b"},
+		},
+		{
+			name:   "pitchfork stops at the shortest set",
+			attack: IntruderAttack{Mode: IntruderPitchfork, Positions: positions(2), Payloads: [][]string{{"a", "b", "c"}, {"1", "2"}}},
+			combos: []string{"a 1", "b 2"},
+		},
+		{
+			name:   "cluster bomb",
+			attack: IntruderAttack{Mode: IntruderClusterBomb, Positions: positions(3), Payloads: [][]string{{"a", "b"}, {"1"}, {"x", "y", "z"}}},
+			combos: []string{"a 1 x", "a 1 y", "a 1 z", "b 1 x", "b 1 y", "b 1 z"},
+		},
+		{
+			name:   "cluster bomb with an empty set",
+			attack: IntruderAttack{Mode: IntruderClusterBomb, Positions: positions(2), Payloads: [][]string{{"a"}, {}}},
+			combos: []string{},
+		},
+		{
+			name:     "no positions",
+			attack:   IntruderAttack{Payloads: [][]string{{"a"}}},
+			errorMsg: "no positions",
+		},
+		{
+			name:     "no payloads",
+			attack:   IntruderAttack{Positions: positions(1)},
+			errorMsg: "no payloads",
+		},
+		{
+			name:     "pitchfork needs a set per position",
+			attack:   IntruderAttack{Mode: IntruderPitchfork, Positions: positions(2), Payloads: [][]string{{"a"}}},
+			errorMsg: "needs 2 payload sets",
+		},
+		{
+			name:     "unknown mode",
+			attack:   IntruderAttack{Mode: "shotgun", Positions: positions(1), Payloads: [][]string{{"a"}}},
+			errorMsg: "unknown intruder mode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := tt.attack.Combinations()
+			if tt.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Fatalf("err = %v, want %q", err, tt.errorMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			combos := make([]string, 0, c.Len())
+			for i := 0; i < c.Len(); i++ {
+				combo := c.At(i)
+				for j := range combo {
+					if combo[j] == intruderKeep {
+						combo[j] = keep
+					}
+				}
+				combos = append(combos, strings.Join(combo, " "))
+			}
+			if fmt.Sprint(combos) != fmt.Sprint(tt.combos) {
+				t.Errorf("combinations %v, want %v", combos, tt.combos)
+			}
+		})
+	}
+}
+
+func TestIntruderCombinationsAreLazy(t *testing.T) {
+	set := make([]string, 1000)
+	for i := range set {
+		set[i] = fmt.Sprint(i)
+	}
+	attack := IntruderAttack{Mode: IntruderClusterBomb, Positions: make([]IntruderPosition, 3), Payloads: [][]string{set, set, set}}
+	c, err := attack.Combinations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 1000*1000*1000 {
+		t.Fatalf("len = %d", c.Len())
+	}
+	if got := strings.Join(c.At(c.Len()-1), " "); got != "999 999 999" {
+		t.Errorf("last combination = %s", got)
+	}
+	if got := strings.Join(c.At(1001), " "); got != "0 1 1" {
+		t.Errorf("combination 1001 = %s", got)
+	}
+
+	big := make([]string, 10000)
+	attack.Payloads = [][]string{big, big, big}
+	if _, err = attack.Combinations(); err == nil {
+		t.Errorf("10000^3 combinations are accepted")
+	}
+}
+
+func TestIntruderRawPositions(t *testing.T) {
+	const base = "GET /a?x=111&y=222 HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	raw := func(start, end int) IntruderPosition {
+		return IntruderPosition{Kind: "raw", Start: start, End: end}
+	}
+	tests := []struct {
+		name      string
+		positions []IntruderPosition
+		payloads  []string
+		uri       string
+		err       bool
+	}{
+		{"sorted", []IntruderPosition{raw(9, 12), raw(15, 18)}, []string{"1", "2"}, "/a?x=1&y=2", false},
+		{"unsorted", []IntruderPosition{raw(15, 18), raw(9, 12)}, []string{"2", "1"}, "/a?x=1&y=2", false},
+		{"shorter payloads", []IntruderPosition{raw(15, 18), raw(5, 6)}, []string{"", ""}, "/?x=111&y=", false},
+		{"longer payload", []IntruderPosition{raw(15, 18), raw(9, 12)}, []string{"22222", "11111"}, "/a?x=11111&y=22222", false},
+		{"kept", []IntruderPosition{raw(15, 18), raw(9, 12)}, []string{intruderKeep, "1"}, "/a?x=1&y=222", false},
+		{"adjacent", []IntruderPosition{raw(9, 12), raw(12, 14)}, []string{"1", "+z"}, "/a?x=1+z=222", false},
+		{"overlapping", []IntruderPosition{raw(9, 14), raw(12, 18)}, []string{"1", "2"}, "", true},
+		{"out of the request", []IntruderPosition{raw(9, 12), raw(40, len(base)+1)}, []string{"1", "2"}, "", true},
+		{"negative", []IntruderPosition{raw(-1, 2)}, []string{"1"}, "", true},
+		{"end before start", []IntruderPosition{raw(12, 9)}, []string{"1"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attack := &IntruderAttack{Scheme: "https", Positions: tt.positions}
+			if err := attack.checkRawPositions(base); (err != nil) != tt.err {
+				t.Fatalf("check error %v, want error %v", err, tt.err)
+			}
+			req, err := attack.Request(base, tt.payloads)
+			if tt.err {
+				if err == nil {
+					t.Errorf("request is built")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := req.URL.RequestURI(); got != tt.uri {
+				t.Errorf("uri %s, want %s", got, tt.uri)
+			}
+		})
+	}
+
+	// used to slice past the shortened request
+	attack := &IntruderAttack{Scheme: "https", Positions: []IntruderPosition{raw(20, 25), raw(0, 10)}}
+	if _, err := attack.Request(base, []string{"", ""}); err == nil {
+		t.Errorf("a request without the method is built")
+	}
+
+	in := NewIntruder(nil, nil, "")
+	attack = &IntruderAttack{Raw: base, Mode: IntruderBatteringRam, Positions: []IntruderPosition{raw(9, 14), raw(12, 18)}, Payloads: [][]string{{"1"}}}
+	if _, err := in.Start(attack); err == nil {
+		t.Errorf("overlapping positions are started")
+	}
+	// markers come before the given positions
+	attack = &IntruderAttack{Raw: "GET /a?x=§111§&y=222 HTTP/1.1\r\nHost: example.com\r\n\r\n", Mode: IntruderBatteringRam, Positions: []IntruderPosition{raw(5, 10)}, Payloads: [][]string{{""}}}
+	if _, err := in.Start(attack); err == nil {
+		t.Errorf("a position overlapping a marker is started")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/elazarl/goproxy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// MapLocalRule serves a file for urls matching Match.
+// Path is a file or a directory, $1 is expanded from Match. For a directory
+// the rest of the url after the match is the file in it.
+type MapLocalRule struct {
+	ID      string `json:"id"`
+	Enabled bool   `json:"enabled"`
+	Match   string `json:"match"`
+	Path    string `json:"path"`
+
+	re *regexp.Regexp
+}
+
+// MapRemoteRule sends the request to URL, the matched part of the url is replaced with it
+type MapRemoteRule struct {
+	ID      string `json:"id"`
+	Enabled bool   `json:"enabled"`
+	Match   string `json:"match"`
+	URL     string `json:"url"`
+
+	re *regexp.Regexp
+}
+
+// mapRemoteOrigin is ctx.UserData of a request mapped to another url, the url it had before
+type mapRemoteOrigin struct {
+	url *url.URL
+}
+
+// MapRules are checked before the cache, the first enabled matching rule is used
+type MapRules struct {
+	mux    *sync.RWMutex
+	Local  []*MapLocalRule  `json:"local"`
+	Remote []*MapRemoteRule `json:"remote"`
+
+	filename string
+}
+
+func NewMapRules(filename string) (*MapRules, error) {
+	m := &MapRules{mux: &sync.RWMutex{}, Local: []*MapLocalRule{}, Remote: []*MapRemoteRule{}, filename: filename}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	c := &MapRules{}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", filename)
+	}
+	if err = m.set(c.Local, c.Remote); err != nil {
+		return nil, errors.Wrapf(err, "map rules %s", filename)
+	}
+	return m, nil
+}
+
+func (m *MapRules) set(local []*MapLocalRule, remote []*MapRemoteRule) error {
+	if local == nil {
+		local = []*MapLocalRule{}
+	}
+	if remote == nil {
+		remote = []*MapRemoteRule{}
+	}
+	var err error
+	for _, rule := range local {
+		if rule.Path == "" {
+			return errors.Errorf("map local %s: empty path", rule.ID)
+		}
+		if rule.re, err = regexp.Compile(rule.Match); err != nil {
+			return errors.Wrapf(err, "map local %s", rule.ID)
+		}
+	}
+	for _, rule := range remote {
+		if rule.URL == "" {
+			return errors.Errorf("map remote %s: empty url", rule.ID)
+		}
+		if rule.re, err = regexp.Compile(rule.Match); err != nil {
+			return errors.Wrapf(err, "map remote %s", rule.ID)
+		}
+	}
+	m.mux.Lock()
+	m.Local, m.Remote = local, remote
+	m.mux.Unlock()
+	return nil
+}
+
+// Set replaces the rules and saves them
+func (m *MapRules) Set(local []*MapLocalRule, remote []*MapRemoteRule) error {
+	if err := m.set(local, remote); err != nil {
+		return errors.WithStack(err)
+	}
+	data, err := json.MarshalIndent(m.Snapshot(), "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ioutil.WriteFile(m.filename, data, 0644))
+}
+
+func (m *MapRules) Snapshot() MapRules {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return MapRules{Local: m.Local, Remote: m.Remote}
+}
+
+// MapRemote rewrites req.URL and returns the original url if a rule matched
+func (m *MapRules) MapRemote(req *http.Request) (string, error) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	original := req.URL.String()
+	for _, rule := range m.Remote {
+		if !rule.Enabled || !rule.re.MatchString(original) {
+			continue
+		}
+		u, err := url.Parse(rule.re.ReplaceAllString(original, rule.URL))
+		if err != nil {
+			return "", errors.Wrapf(err, "map remote %s", rule.ID)
+		}
+		req.URL, req.Host = u, u.Host
+		return original, nil
+	}
+	return "", nil
+}
+
+// MapLocal returns the file to serve for req, empty if no rule matched
+func (m *MapRules) MapLocal(req *http.Request) string {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	s := req.URL.String()
+	for _, rule := range m.Local {
+		if !rule.Enabled {
+			continue
+		}
+		loc := rule.re.FindStringSubmatchIndex(s)
+		if loc == nil {
+			continue
+		}
+		filename, ok := expandLocalPath(rule.Path, rule.re, s, loc)
+		if !ok {
+			logrus.Warnf("map local %s: %s goes out of %s", rule.ID, s, rule.Path)
+			continue
+		}
+		if info, err := os.Stat(filename); err == nil && info.IsDir() {
+			rest := s[loc[1]:]
+			if i := strings.IndexAny(rest, "?#"); i >= 0 {
+				rest = rest[:i]
+			}
+			rest, _ = url.PathUnescape(rest)
+			// Clean of a rooted path can't go above the directory
+			filename = filepath.Join(filename, filepath.FromSlash(filepath.Clean("/"+rest)))
+			if strings.HasSuffix(rest, "/") || rest == "" {
+				filename = filepath.Join(filename, "index.html")
+			}
+		}
+		return filename
+	}
+	return ""
+}
+
+// expandLocalPath expands $1 of path from the url, the result has to stay in the
+// directory of the path part before the first $ so .. in the url can't read other files
+func expandLocalPath(path string, re *regexp.Regexp, s string, loc []int) (string, bool) {
+	i := strings.IndexByte(path, '$')
+	if i < 0 {
+		return path, true
+	}
+	root := filepath.Dir(path[:i])
+	filename := filepath.Clean(string(re.ExpandString(nil, path, s, loc)))
+	rel, err := filepath.Rel(root, filename)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filename, true
+}
+
+// LocalResponse reads filename, the content type is guessed by extension, then by content
+func LocalResponse(req *http.Request, filename string) *http.Response {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if os.IsNotExist(err) {
+			status = http.StatusNotFound
+		}
+		return goproxy.NewResponse(req, goproxy.ContentTypeText, status, "anothergoproxy: map local: "+err.Error())
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return goproxy.NewResponse(req, contentType, http.StatusOK, string(data))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMapLocal(t *testing.T) {
+	root, err := ioutil.TempDir("", "anothergoproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	local := func(path string) string {
+		if path == "" {
+			return ""
+		}
+		return filepath.FromSlash(strings.Replace(path, "/srv/build", filepath.ToSlash(root), 1))
+	}
+	tests := []struct {
+		name  string
+		match string
+		path  string
+		url   string
+		file  string
+	}{
+		{"file", `^https://a\.com/app\.js$`, "/srv/build/app.js", "https://a.com/app.js", "/srv/build/app.js"},
+		{"expanded", `^https://a\.com/js/(.*)$`, "/srv/build/$1", "https://a.com/js/app.js", "/srv/build/app.js"},
+		{"expanded in a name", `^https://a\.com/js/(\w+)\.js$`, "/srv/build/v-$1.js", "https://a.com/js/app.js", "/srv/build/v-app.js"},
+		{"expanded dot dot", `^https://a\.com/js/(.*)$`, "/srv/build/$1", "https://a.com/js/../../etc/passwd", ""},
+		{"expanded dot dot inside", `^https://a\.com/js/(.*)$`, "/srv/build/$1", "https://a.com/js/a/../app.js", "/srv/build/app.js"},
+		{"expanded parent", `^https://a\.com/js/(.*)$`, "/srv/build/$1", "https://a.com/js/..", ""},
+		{"directory", `^https://a\.com/static/`, "/srv/build", "https://a.com/static/js/app.js", "/srv/build/js/app.js"},
+		{"directory index", `^https://a\.com/static/`, "/srv/build", "https://a.com/static/", "/srv/build/index.html"},
+		{"directory dot dot", `^https://a\.com/static/`, "/srv/build", "https://a.com/static/%2e%2e/%2e%2e/etc/passwd", "/srv/build/etc/passwd"},
+		{"no match", `^https://b\.com/`, "/srv/build/app.js", "https://a.com/app.js", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MapRules{mux: &sync.RWMutex{}}
+			if err := m.set([]*MapLocalRule{{ID: "a", Enabled: true, Match: tt.match, Path: local(tt.path)}}, nil); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := m.MapLocal(httptest.NewRequest("GET", tt.url, nil)), local(tt.file); got != want {
+				t.Errorf("MapLocal(%s) = %q, want %q", tt.url, got, want)
+			}
+		})
+	}
+}
+
+// README rules against urls as goproxy builds them inside a CONNECT tunnel
+func TestMapRulesMITMURL(t *testing.T) {
+	root, err := ioutil.TempDir("", "anothergoproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	m := &MapRules{mux: &sync.RWMutex{}}
+	err = m.set(
+		[]*MapLocalRule{{ID: "bundle", Enabled: true, Match: `^https://cdn\.example\.com/static/`, Path: root}},
+		[]*MapRemoteRule{{ID: "api", Enabled: true, Match: `^https://api\.example\.com/v1/(.*)$`, URL: "http://localhost:3000/$1"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := mitmRequest("GET", "https://cdn.example.com:443/static/js/app.js").Request
+	if got, want := m.MapLocal(req), filepath.Join(root, "js", "app.js"); got != want {
+		t.Errorf("map local %q, want %q", got, want)
+	}
+
+	req = mitmRequest("GET", "https://api.example.com:443/v1/users?id=1").Request
+	from, err := m.MapRemote(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != "https://api.example.com/v1/users?id=1" || req.URL.String() != "http://localhost:3000/users?id=1" {
+		t.Errorf("map remote %s -> %s", from, req.URL)
+	}
+}
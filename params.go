@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ParamQuery  = "query"
+	ParamForm   = "form"
+	ParamJSON   = "json"
+	ParamCookie = "cookie"
+	ParamPath   = "path"
+)
+
+type RequestParam struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Params extracts query, form, json (flattened to dot separated paths), cookie and path segment values
+func (req RequestDTO) Params() []RequestParam {
+	params := make([]RequestParam, 0)
+	for _, pair := range strings.Split(req.URL.RawQuery, "&") {
+		if name, value, ok := splitPair(pair); ok {
+			params = append(params, RequestParam{ParamQuery, name, value})
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		for _, pair := range strings.Split(string(req.body), "&") {
+			if name, value, ok := splitPair(pair); ok {
+				params = append(params, RequestParam{ParamForm, name, value})
+			}
+		}
+	case strings.HasSuffix(mediaType, "json"):
+		if doc, err := decodeJSON(req.body); err == nil {
+			for _, kv := range jsonFlatten(doc, "") {
+				params = append(params, RequestParam{ParamJSON, kv[0], kv[1]})
+			}
+		}
+	}
+
+	for _, c := range req.Cookies() {
+		params = append(params, RequestParam{ParamCookie, c.Name, c.Value})
+	}
+	for i, segment := range strings.Split(strings.Trim(req.URL.Path, "/"), "/") {
+		if segment != "" {
+			params = append(params, RequestParam{ParamPath, strconv.Itoa(i), segment})
+		}
+	}
+	return params
+}
+
+func splitPair(pair string) (string, string, bool) {
+	if pair == "" {
+		return "", "", false
+	}
+	parts := strings.SplitN(pair, "=", 2)
+	name, err := url.QueryUnescape(parts[0])
+	if err != nil {
+		name = parts[0]
+	}
+	if len(parts) == 1 {
+		return name, "", true
+	}
+	value, err := url.QueryUnescape(parts[1])
+	if err != nil {
+		value = parts[1]
+	}
+	return name, value, true
+}
+
+// jsonFlatten returns [path, value] of scalar values, strings are not quoted
+func jsonFlatten(doc interface{}, prefix string) [][2]string {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+		return prefix + "." + k
+	}
+	res := make([][2]string, 0)
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			res = append(res, jsonFlatten(v[k], join(k))...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			res = append(res, jsonFlatten(item, join(strconv.Itoa(i)))...)
+		}
+	case string:
+		res = append(res, [2]string{prefix, v})
+	case nil:
+		res = append(res, [2]string{prefix, "null"})
+	default:
+		res = append(res, [2]string{prefix, fmt.Sprint(v)})
+	}
+	return res
+}
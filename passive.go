@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PassiveCheck looks at one exchange without sending anything.
+// resp has the headers the server sent, before any handler rewrote them.
+type PassiveCheck interface {
+	Name() string
+	Check(req *RequestDTO, resp *ResponseDTO) []*Finding
+}
+
+// PassiveScanner runs registered checks and stores their findings
+type PassiveScanner struct {
+	mux      *sync.RWMutex
+	checks   []PassiveCheck
+	findings *Findings
+}
+
+func NewPassiveScanner(findings *Findings, checks ...PassiveCheck) *PassiveScanner {
+	return &PassiveScanner{mux: &sync.RWMutex{}, checks: checks, findings: findings}
+}
+
+func DefaultPassiveChecks() []PassiveCheck {
+	return []PassiveCheck{
+		ReflectionCheck{},
+		SecurityHeadersCheck{},
+		CookieCheck{},
+		ErrorDisclosureCheck{},
+		SecretsCheck{},
+		MixedContentCheck{},
+		CORSCheck{},
+	}
+}
+
+func (s *PassiveScanner) Register(check PassiveCheck) {
+	s.mux.Lock()
+	s.checks = append(s.checks, check)
+	s.mux.Unlock()
+}
+
+func (s *PassiveScanner) Checks() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	res := make([]string, 0, len(s.checks))
+	for _, check := range s.checks {
+		res = append(res, check.Name())
+	}
+	return res
+}
+
+// Scan fills the common fields of findings, they are deduplicated by Findings.Add
+func (s *PassiveScanner) Scan(req *RequestDTO, resp *ResponseDTO) {
+	s.mux.RLock()
+	checks := s.checks
+	s.mux.RUnlock()
+	for _, check := range checks {
+		for _, f := range check.Check(req, resp) {
+			if f.Type == "" {
+				f.Type = check.Name()
+			}
+			if f.Host == "" {
+				f.Host, f.Path, f.URL = req.URL.Host, req.URL.Path, req.URL.String()
+			}
+			if f.HistoryID == 0 && req.history != nil {
+				f.HistoryID = req.history.ID
+			}
+			if err := s.findings.Add(f); err != nil {
+				logrus.WithError(err).WithField("check", check.Name()).Error("add finding")
+			}
+		}
+	}
+}
+
+type ReflectionCheck struct{}
+
+func (ReflectionCheck) Name() string {
+	return "reflection"
+}
+
+func (ReflectionCheck) Check(req *RequestDTO, resp *ResponseDTO) []*Finding {
+	return DetectReflections(req, resp)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HSTS max-age below half a year is weak
+const hstsMinMaxAge = 15552000
+
+func responseMediaType(resp *ResponseDTO) string {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType
+}
+
+func responseText(resp *ResponseDTO) []byte {
+	body, err := decodeBody(resp.Header.Get("Content-Encoding"), resp.body)
+	if err != nil {
+		return resp.body
+	}
+	return body
+}
+
+// SecurityHeadersCheck reports missing or weak security headers
+type SecurityHeadersCheck struct{}
+
+func (SecurityHeadersCheck) Name() string {
+	return "security-headers"
+}
+
+func (SecurityHeadersCheck) Check(req *RequestDTO, resp *ResponseDTO) []*Finding {
+	res := make([]*Finding, 0)
+	add := func(severity, title, evidence string) {
+		res = append(res, &Finding{Severity: severity, Title: title, Evidence: evidence})
+	}
+	h := resp.Header
+	if v := h.Get("X-Content-Type-Options"); v == "" {
+		add(SeverityLow, "missing X-Content-Type-Options", "")
+	} else if !strings.EqualFold(strings.TrimSpace(v), "nosniff") {
+		add(SeverityLow, "weak X-Content-Type-Options", v)
+	}
+	if req.URL.Scheme == "https" {
+		if v := h.Get("Strict-Transport-Security"); v == "" {
+			add(SeverityLow, "missing Strict-Transport-Security", "")
+		} else if maxAge := hstsMaxAge(v); maxAge < hstsMinMaxAge {
+			add(SeverityLow, "weak Strict-Transport-Security max-age", v)
+		}
+	}
+	for _, name := range []string{"Server", "X-Powered-By", "X-AspNet-Version", "X-AspNetMvc-Version"} {
+		if v := h.Get(name); v != "" && strings.ContainsAny(v, "0123456789") {
+			add(SeverityInfo, "version disclosure in "+name, v)
+		}
+	}
+
+	if responseMediaType(resp) != "text/html" {
+		return res
+	}
+	csp := h.Get("Content-Security-Policy")
+	if csp == "" {
+		add(SeverityMedium, "missing Content-Security-Policy", "")
+	}
+	if xfo := h.Get("X-Frame-Options"); xfo == "" && !strings.Contains(csp, "frame-ancestors") {
+		add(SeverityMedium, "missing X-Frame-Options or CSP frame-ancestors", "")
+	} else if xfo != "" && !strings.EqualFold(xfo, "DENY") && !strings.EqualFold(xfo, "SAMEORIGIN") {
+		add(SeverityLow, "weak X-Frame-Options", xfo)
+	}
+	if h.Get("Referrer-Policy") == "" {
+		add(SeverityInfo, "missing Referrer-Policy", "")
+	} else if v := strings.ToLower(h.Get("Referrer-Policy")); strings.Contains(v, "unsafe-url") {
+		add(SeverityLow, "weak Referrer-Policy", v)
+	}
+	return res
+}
+
+func hstsMaxAge(v string) int {
+	for _, directive := range strings.Split(v, ";") {
+		parts := strings.SplitN(strings.TrimSpace(directive), "=", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "max-age") {
+			n, _ := strconv.Atoi(strings.Trim(parts[1], `" `))
+			return n
+		}
+	}
+	return 0
+}
+
+// CookieCheck reports cookies set without Secure, HttpOnly or SameSite
+type CookieCheck struct{}
+
+func (CookieCheck) Name() string {
+	return "cookies"
+}
+
+func (CookieCheck) Check(req *RequestDTO, resp *ResponseDTO) []*Finding {
+	res := make([]*Finding, 0)
+	for _, raw := range resp.Header.Values("Set-Cookie") {
+		cookies := (&http.Response{Header: http.Header{"Set-Cookie": {raw}}}).Cookies()
+		if len(cookies) == 0 {
+			continue
+		}
+		c := cookies[0]
+		if c.MaxAge < 0 {
+			// deleted
+			continue
+		}
+		add := func(severity, flag string) {
+			res = append(res, &Finding{
+				Severity: severity,
+				Title:    fmt.Sprintf("cookie %s without %s", c.Name, flag),
+				Evidence: raw,
+			})
+		}
+		if !c.Secure && req.URL.Scheme == "https" {
+			add(SeverityLow, "Secure")
+		}
+		if !c.HttpOnly {
+			add(SeverityLow, "HttpOnly")
+		}
+		if !strings.Contains(strings.ToLower(raw), "samesite") {
+			add(SeverityInfo, "SameSite")
+		} else if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+			add(SeverityLow, "Secure (SameSite=None)")
+		}
+	}
+	return res
+}
+
+type patternCheck struct {
+	title    string
+	severity string
+	re       *regexp.Regexp
+}
+
+// ErrorDisclosureCheck finds stack traces and verbose error pages
+type ErrorDisclosureCheck struct{}
+
+var errorDisclosurePatterns = []patternCheck{
+	{"java stack trace", SeverityMedium, regexp.MustCompile(`\bat [\w$.]+\([\w$]+\.java:\d+\)`)},
+	{"python traceback", SeverityMedium, regexp.MustCompile(`Traceback \(most recent call last\):`)},
+	{"php error", SeverityMedium, regexp.MustCompile(`(?:Fatal error|Warning|Notice|Parse error)</b>?:.{1,300}? on line <b>?\d+`)},
+	{".net error", SeverityMedium, regexp.MustCompile(`Server Error in '[^']*' Application|System\.[A-Za-z.]+Exception:`)},
+	{"go panic", SeverityMedium, regexp.MustCompile(`goroutine \d+ \[running\]:`)},
+	{"node stack trace", SeverityMedium, regexp.MustCompile(`\n\s+at [^\n]+\((?:/|[A-Z]:\\)[^\n]+\.js:\d+:\d+\)`)},
+	{"ruby backtrace", SeverityMedium, regexp.MustCompile(`\.rb:\d+:in ` + "`")},
+	{"sql error", SeverityHigh, regexp.MustCompile(`You have an error in your SQL syntax|ORA-\d{5}|PG::\w+Error|SQLSTATE\[\w+\]|Unclosed quotation mark after the character string|SQLite3?::|sqlite3\.OperationalError`)},
+	{"debug page", SeverityMedium, regexp.MustCompile(`Whoops! There was an error|Werkzeug Debugger|DEBUG = True|<title>Action Controller: Exception caught</title>`)},
+}
+
+func (ErrorDisclosureCheck) Name() string {
+	return "error-disclosure"
+}
+
+func (ErrorDisclosureCheck) Check(req *RequestDTO, resp *ResponseDTO) []*Finding {
+	switch mediaType := responseMediaType(resp); {
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "font/"),
+		strings.HasPrefix(mediaType, "video/"), strings.HasPrefix(mediaType, "audio/"):
+		return nil
+	}
+	return matchPatterns(responseText(resp), errorDisclosurePatterns)
+}
+
+// SecretsCheck finds api keys and tokens in javascript
+type SecretsCheck struct{}
+
+var secretPatterns = []patternCheck{
+	{"aws access key", SeverityHigh, regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"google api key", SeverityMedium, regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`)},
+	{"slack token", SeverityHigh, regexp.MustCompile(`\bxox[abposr]-[0-9A-Za-z\-]{10,}`)},
+	{"github token", SeverityHigh, regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{"stripe secret key", SeverityHigh, regexp.MustCompile(`\b[sr]k_live_[0-9A-Za-z]{20,}\b`)},
+	{"private key", SeverityHigh, regexp.MustCompile(`-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP )?PRIVATE KEY`)},
+	{"jwt", SeverityLow, regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]{10,}\.eyJ[A-Za-z0-9_\-]{10,}\.[A-Za-z0-9_\-]{10,}`)},
+	{"hardcoded secret", SeverityMedium, regexp.MustCompile(`(?i)["']?(?:api[_-]?key|api[_-]?secret|client[_-]?secret|access[_-]?token|auth[_-]?token|secret[_-]?key|password|passwd)["']?\s*[:=]\s*["'][^"'\s]{8,}["']`)},
+}
+
+func (SecretsCheck) Name() string {
+	return "secrets"
+}
+
+func (SecretsCheck) Check(req *RequestDTO, resp *ResponseDTO) []*Finding {
+	mediaType := responseMediaType(resp)
+	isJS := strings.HasSuffix(mediaType, "javascript") || strings.HasSuffix(mediaType, "ecmascript") ||
+		strings.HasSuffix(req.URL.Path, ".js")
+	// inline scripts
+	if !isJS && mediaType != "text/html" {
+		return nil
+	}
+	return matchPatterns(responseText(resp), secretPatterns)
+}
+
+func matchPatterns(body []byte, patterns []patternCheck) []*Finding {
+	res := make([]*Finding, 0)
+	for _, p := range patterns {
+		loc := p.re.FindIndex(body)
+		if loc == nil {
+			continue
+		}
+		res = append(res, &Finding{
+			Severity: p.severity,
+			Title:    p.title,
+			Evidence: snippet(body, loc[0], loc[1]-loc[0]),
+		})
+	}
+	return res
+}
+
+// MixedContentCheck finds http:// resources on https pages
+type MixedContentCheck struct{}
+
+var mixedContentRe = regexp.MustCompile(`(?i)<(script|iframe|frame|link|object|embed|img|audio|video|source|form)\b[^>]*?\s(?:src|href|data|action)\s*=\s*["']?(http://[^"'\s>]+)`)
+
+func (MixedContentCheck) Name() string {
+	return "mixed-content"
+}
+
+func (MixedContentCheck) Check(req *RequestDTO, resp *ResponseDTO) []*Finding {
+	if req.URL.Scheme != "https" || responseMediaType(resp) != "text/html" {
+		return nil
+	}
+	res := make([]*Finding, 0)
+	seen := make(map[string]bool)
+	for _, m := range mixedContentRe.FindAllSubmatch(responseText(resp), -1) {
+		tag := strings.ToLower(string(m[1]))
+		// active content is blocked by browsers, passive is loaded with a warning
+		severity := SeverityLow
+		switch tag {
+		case "script", "iframe", "frame", "link", "object", "embed", "form":
+			severity = SeverityMedium
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		res = append(res, &Finding{
+			Severity: severity,
+			Title:    "mixed content in <" + tag + ">",
+			Evidence: string(m[2]),
+		})
+	}
+	return res
+}
+
+// corsForgedOrigin is true when the Referer of req is from another origin than its Origin,
+// browsers send both from the same page
+func corsForgedOrigin(req *RequestDTO, origin string) bool {
+	referer, err := url.Parse(req.Header.Get("Referer"))
+	if err != nil || referer.Host == "" {
+		return false
+	}
+	return !strings.EqualFold(referer.Scheme+"://"+referer.Host, origin)
+}
+
+// corsSameSite compares the last two labels of the hosts, close enough without the public suffix list
+func corsSameSite(origin, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	site := func(host string) string {
+		labels := strings.Split(strings.ToLower(strings.TrimSuffix(host, ".")), ".")
+		if len(labels) > 2 {
+			labels = labels[len(labels)-2:]
+		}
+		return strings.Join(labels, ".")
+	}
+	return site(u.Hostname()) == site(host)
+}
+
+// CORSCheck reports the CORS headers returned and permissive ones
+type CORSCheck struct{}
+
+func (CORSCheck) Name() string {
+	return "cors"
+}
+
+func (CORSCheck) Check(req *RequestDTO, resp *ResponseDTO) []*Finding {
+	acao := resp.Header.Get("Access-Control-Allow-Origin")
+	if acao == "" {
+		return nil
+	}
+	credentials := strings.EqualFold(resp.Header.Get("Access-Control-Allow-Credentials"), "true")
+	evidence := fmt.Sprintf("Access-Control-Allow-Origin: %s", acao)
+	if credentials {
+		evidence += "; Access-Control-Allow-Credentials: true"
+	}
+	for _, name := range []string{"Access-Control-Allow-Methods", "Access-Control-Allow-Headers", "Access-Control-Expose-Headers"} {
+		if v := resp.Header.Get(name); v != "" {
+			evidence += fmt.Sprintf("; %s: %s", name, v)
+		}
+	}
+	origin := req.Header.Get("Origin")
+	reflected := origin != "" && origin != "null" && acao == origin
+	f := &Finding{Severity: SeverityInfo, Title: "CORS headers", Evidence: evidence}
+	switch {
+	case reflected && corsForgedOrigin(req, origin):
+		// the page sending it is elsewhere, the origin was set by hand (repeater, intruder) and still allowed
+		f.Severity, f.Title = SeverityLow, "CORS arbitrary origin reflected"
+		if credentials {
+			f.Severity, f.Title = SeverityHigh, "CORS arbitrary origin reflected with credentials"
+		}
+	case reflected && !corsSameSite(origin, req.URL.Hostname()):
+		// likely an allowlisted partner, check it with an arbitrary origin in the repeater
+		f.Title = "CORS cross-site origin allowed"
+		if credentials {
+			f.Severity, f.Title = SeverityMedium, "CORS cross-site origin allowed with credentials"
+		}
+	case reflected:
+		f.Title = "CORS same site origin allowed"
+	case acao == "null":
+		f.Severity, f.Title = SeverityMedium, "CORS allows null origin"
+		if credentials {
+			f.Severity = SeverityHigh
+		}
+	case acao == "*" && credentials:
+		f.Severity, f.Title = SeverityLow, "CORS wildcard with credentials"
+	case acao == "*":
+		f.Title = "CORS wildcard origin"
+	}
+	return []*Finding{f}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCORSCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		origin      string
+		referer     string
+		acao        string
+		credentials bool
+		severity    string
+		title       string
+	}{
+		{"no cors", "https://evil.com", "", "", false, "", ""},
+		{"same site", "https://www.a.com", "https://www.a.com/page", "https://www.a.com", true, SeverityInfo, "CORS same site origin allowed"},
+		{"allowlisted partner", "https://partner.com", "https://partner.com/", "https://partner.com", true, SeverityMedium, "CORS cross-site origin allowed with credentials"},
+		{"partner without referer", "https://partner.com", "", "https://partner.com", false, SeverityInfo, "CORS cross-site origin allowed"},
+		{"forged origin", "https://evil.com", "https://www.a.com/page", "https://evil.com", true, SeverityHigh, "CORS arbitrary origin reflected with credentials"},
+		{"forged origin without credentials", "https://evil.com", "https://www.a.com/page", "https://evil.com", false, SeverityLow, "CORS arbitrary origin reflected"},
+		{"forged same site origin", "https://evil.a.com", "https://www.a.com/", "https://evil.a.com", true, SeverityHigh, "CORS arbitrary origin reflected with credentials"},
+		{"null with credentials", "null", "", "null", true, SeverityHigh, "CORS allows null origin"},
+		{"null", "null", "", "null", false, SeverityMedium, "CORS allows null origin"},
+		{"wildcard", "https://evil.com", "", "*", false, SeverityInfo, "CORS wildcard origin"},
+		{"other origin allowed", "https://evil.com", "", "https://www.a.com", true, SeverityInfo, "CORS headers"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := []string{"Origin", tt.origin}
+			if tt.referer != "" {
+				header = append(header, "Referer", tt.referer)
+			}
+			req := newTestRequest(http.MethodGet, "https://api.a.com/me", "", "", header...)
+			resp := &ResponseDTO{Response: &http.Response{StatusCode: 200, Header: http.Header{}}}
+			if tt.acao != "" {
+				resp.Header.Set("Access-Control-Allow-Origin", tt.acao)
+			}
+			if tt.credentials {
+				resp.Header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			findings := CORSCheck{}.Check(req, resp)
+			if tt.title == "" {
+				if len(findings) != 0 {
+					t.Errorf("findings without cors headers")
+				}
+				return
+			}
+			if len(findings) != 1 {
+				t.Fatalf("%d findings", len(findings))
+			}
+			if f := findings[0]; f.Severity != tt.severity || f.Title != tt.title {
+				t.Errorf("%s %s, want %s %s", f.Severity, f.Title, tt.severity, tt.title)
+			}
+		})
+	}
+}
+
+// testCheck finds every exchange
+type testCheck struct{}
+
+func (testCheck) Name() string {
+	return "test"
+}
+
+func (testCheck) Check(req *RequestDTO, resp *ResponseDTO) []*Finding {
+	return []*Finding{{Severity: SeverityInfo, Title: "test"}}
+}
+
+func TestPassiveScannerMITMURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anothergoproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	findings, err := NewFindings(filepath.Join(dir, "findings.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer findings.Close()
+
+	scanner := NewPassiveScanner(findings, testCheck{})
+	resp := &ResponseDTO{Response: &http.Response{StatusCode: 200, Header: http.Header{}}}
+	scanner.Scan(mitmRequest("GET", "https://Example.com:443/a"), resp)
+	found, err := findings.Find(FindingFilter{Host: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].URL != "https://example.com/a" {
+		t.Errorf("host example.com doesn't find the finding of a MITM'd request: %+v", found)
+	}
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
+	"net"
 	"net/http"
-	"net/url"
-	"regexp"
 
 	"github.com/elazarl/goproxy"
 	"github.com/fatih/color"
@@ -13,30 +12,78 @@ import (
 
 type Proxy struct {
 	*goproxy.ProxyHttpServer
+	ca            *CA
+	cacheHandlers *CacheHandlers
+	repeater      *Repeater
+	intruder      *Intruder
+	relax         *RelaxPolicies
+	router        *Router
 }
 
 func NewProxy() (*Proxy, error) {
 	proxy := goproxy.NewProxyHttpServer()
-	if options.UpstreamProxyURL != "" {
-		proxy.Tr = &http.Transport{Proxy: func(req *http.Request) (*url.URL, error) {
-			return url.Parse(options.UpstreamProxyURL)
-		}}
-		proxy.ConnectDial = proxy.NewConnectDialToProxy(options.UpstreamProxyURL)
+	router, err := NewRouter(options.RoutesFilename(), options.UpstreamProxyURL)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
-	if options.URLMatch != "" {
-		proxy.OnRequest(goproxy.UrlMatches(regexp.MustCompile(options.URLMatch))).HandleConnect(goproxy.AlwaysMitm)
+
+	ca, err := NewCA()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if proxy.CertStore, err = NewCertStore(ca); err != nil {
+		return nil, errors.WithStack(err)
 	}
+	mitmConnect := ca.ConnectAction()
+	// out of scope hosts are tunneled untouched
+	proxy.OnRequest().HandleConnect(
+		goproxy.FuncHttpsHandler(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+			if !scope.HostInScope("https", host) {
+				return goproxy.OkConnect, host
+			}
+			return mitmConnect, host
+		}),
+	)
 
-	cacheHandlers, err := NewCacheHandlers()
+	wsRelay, err := NewWebSocketRelay(ca, router.DialProtocol)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	proxy.OnRequest().DoFunc(cacheHandlers.requestHandler)
-	proxy.OnResponse().DoFunc(cacheHandlers.responseHandler)
-	proxy.OnResponse().DoFunc(disableCSPHandler)
+	proxy.ConnectDial = func(network, addr string) (net.Conn, error) {
+		if wsRelay.IsRelayAddr(addr) {
+			return net.Dial(network, addr)
+		}
+		return router.Dial(network, addr)
+	}
+
+	cacheHandlers, err := NewCacheHandlers(wsRelay)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	inScope := scope.ReqCondition()
+	proxy.OnRequest().DoFunc(normalizeHandler)
+	// out of scope traffic is routed too
+	proxy.OnRequest().DoFunc(router.requestHandler)
+	proxy.OnRequest(inScope).DoFunc(cacheHandlers.requestHandler)
+	relax, err := NewRelaxPolicies(options.RelaxPolicies)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// responseHandler stores and analyzes the headers before relax policies rewrite them
+	proxy.OnResponse(inScope).DoFunc(cacheHandlers.responseHandler)
+	proxy.OnResponse(inScope).DoFunc(cacheHandlers.replaceHandler)
+	proxy.OnResponse(inScope).DoFunc(cacheHandlers.interceptHandler)
+	proxy.OnResponse(inScope).DoFunc(relax.responseHandler)
 
 	proxy.Verbose = options.Verbose
-	return &Proxy{proxy}, nil
+	repeater := NewRepeater(router, cacheHandlers.history, cacheHandlers.scanner)
+	intruder := NewIntruder(repeater, cacheHandlers.history, options.IntruderPath())
+	return &Proxy{proxy, ca, cacheHandlers, repeater, intruder, relax, router}, nil
+}
+
+// ServeHTTP flushes event streams to plain http clients
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.ProxyHttpServer.ServeHTTP(&flushWriter{ResponseWriter: w}, r)
 }
 
 var reqBodyColor = color.New(color.FgMagenta).SprintFunc()
@@ -46,26 +93,123 @@ var respBodyColor = color.New(color.FgBlue).SprintFunc()
 type CacheHandlers struct {
 	cache          ReqRespCacheI
 	sessionStorage *SessionStorage
+	wsRelay        *WebSocketRelay
+	redirects      *RedirectTracker
+	modes          *CacheModes
+	history        *History
+	findings       *Findings
+	scanner        *PassiveScanner
+	csp            *CSPTracker
+	replacer       *Replacer
+	interceptor    *Interceptor
+	maps           *MapRules
 }
 
-func NewCacheHandlers() (*CacheHandlers, error) {
-	cache, err := NewCacheFile()
+func NewCacheHandlers(wsRelay *WebSocketRelay) (*CacheHandlers, error) {
+	cache, err := NewCache(options.CacheBackend)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	modes, err := NewCacheModes(options.CacheModes, options.FuzzyMatch)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	history, err := NewHistory(options.HistoryDBFilename())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	findings, err := NewFindings(options.FindingsDBFilename())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	replacer, err := NewReplacer(options.ReplaceRulesFilename())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	maps, err := NewMapRules(options.MapRulesFilename())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	csp := NewCSPTracker()
 	return &CacheHandlers{
 		cache:          cache,
 		sessionStorage: NewSessionStorage(),
+		wsRelay:        wsRelay,
+		redirects:      NewRedirectTracker(),
+		modes:          modes,
+		history:        history,
+		findings:       findings,
+		scanner:        NewPassiveScanner(findings, append(DefaultPassiveChecks(), csp)...),
+		csp:            csp,
+		replacer:       replacer,
+		interceptor:    NewInterceptor(),
+		maps:           maps,
 	}, nil
 }
 
 func (c *CacheHandlers) requestHandler(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
-	reqDTO := NewRequestDTO(req)
+	replaced := c.replacer.Request(req)
+	if resp := c.interceptor.Request(req, ctx.Session); resp != nil {
+		return req, resp
+	}
+	origin := req.URL
+	mappedFrom, err := c.maps.MapRemote(req)
+	if err != nil {
+		logrus.WithError(err).Error("map remote")
+	}
+	if mappedFrom != "" {
+		// response handlers are still in scope when the new url is not
+		ctx.UserData = &mapRemoteOrigin{url: origin}
+	}
+	mode := c.modes.Mode(req.URL)
+	reqDTO := NewRequestDTOLimit(req, options.RecordLimit)
+	if reqDTO.truncated {
+		// a cache key of a part of the body would match other requests
+		mode = CacheModePassthrough
+	}
+	reqDTO.cacheMode = mode
+	reqDTO.history = NewHistoryEntry(reqDTO, ctx.Session)
+	reqDTO.history.Replaced = replaced
+	reqDTO.history.MapRemote = mappedFrom
+	reqDTO.history.Truncated = reqDTO.truncated
+	c.history.OnRequest(reqDTO.history, reqDTO)
 	c.sessionStorage.Store(ctx.Session, reqDTO)
 
-	if resp, err := c.cache.Load(reqDTO); err == nil {
-		logrus.Printf("[%d] --> %s %s", ctx.Session, req.Method, urlColor(req.URL))
-		return req, resp.HttpResponse()
+	if isWebSocketRequest(req) {
+		logrus.Printf("[%d] --> WS %s", ctx.Session, urlColor(req.URL))
+		if mode != CacheModePassthrough {
+			// frames are recorded and replayed by the relay
+			c.wsRelay.Redirect(req, ctx.Session, mode)
+		}
+		return req, nil
+	}
+
+	c.redirects.OnRequest(reqDTO)
+
+	if filename := c.maps.MapLocal(req); filename != "" {
+		logrus.Printf("[%d] --> %s %s (map local %s)", ctx.Session, req.Method, urlColor(req.URL), filename)
+		reqDTO.mapLocal = filename
+		c.history.update(reqDTO.history, func(e *HistoryEntry) { e.MapLocal = filename })
+		return req, LocalResponse(req, filename)
+	}
+	if mode.Replay() {
+		if resp, err := c.cache.Load(reqDTO); err == nil {
+			logrus.Printf("[%d] --> %s %s (cache)", ctx.Session, req.Method, urlColor(req.URL))
+			reqDTO.fromCache = true
+			return req, resp.HttpResponse()
+		}
+		if c.modes.FuzzyMatch(req.URL) {
+			if resp, err := LoadClosest(c.cache, reqDTO); err == nil {
+				logrus.Printf("[%d] --> %s %s (cache %s)", ctx.Session, req.Method, urlColor(req.URL), resp.Header.Get(fuzzyMatchHeader))
+				reqDTO.fromCache = true
+				return req, resp.HttpResponse()
+			}
+		}
+	}
+	if mode == CacheModeOffline {
+		logrus.Printf("[%d] --> %s %s (offline miss)", ctx.Session, req.Method, urlColor(req.URL))
+		reqDTO.fromCache = true
+		return req, goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusGatewayTimeout, "anothergoproxy: offline mode, no cached response")
 	}
 
 	logrus.Printf("[%d] --> %s %s", ctx.Session, req.Method, urlColor(req.URL))
@@ -74,22 +218,35 @@ func (c *CacheHandlers) requestHandler(req *http.Request, ctx *goproxy.ProxyCtx)
 }
 
 func (c *CacheHandlers) responseHandler(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+	reqDTO, ok := c.sessionStorage.Load(ctx.Session)
+	if ok {
+		c.history.OnResponse(reqDTO, resp, ctx.Error)
+	}
+	// roundtrip error
+	if resp == nil {
+		return resp
+	}
 	logrus.Printf("[%d] <-- %d %s", ctx.Session, resp.StatusCode, urlColor(ctx.Req.URL))
-	//TODO: websocket support !!
-	if resp.StatusCode == 101 {
+	// websocket handshake, the relay takes care of it
+	if resp.StatusCode == http.StatusSwitchingProtocols {
 		return resp
 	}
-	//TODO:
-	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+	if resp.StatusCode == http.StatusNotModified {
 		return resp
 	}
 	location := resp.Header.Get("Location")
 	if location != "" {
 		logrus.Printf("Location: %s", location)
 	}
-	var reqDTO *RequestDTO
-	var ok bool
-	if reqDTO, ok = c.sessionStorage.Load(ctx.Session); !ok {
+	if !ok {
+		return resp
+	}
+	c.redirects.OnResponse(reqDTO, resp)
+	if reqDTO.fromCache || reqDTO.mapLocal != "" {
+		return resp
+	}
+	if isStreamed(resp) {
+		c.streamResponse(reqDTO, resp)
 		return resp
 	}
 
@@ -98,6 +255,10 @@ func (c *CacheHandlers) responseHandler(resp *http.Response, ctx *goproxy.ProxyC
 		logrus.WithError(err).Error("NewResponseDTO")
 		return resp
 	}
+	go c.analyze(reqDTO, analysisResponse(respDTO))
+	if !reqDTO.cacheMode.Record() {
+		return resp
+	}
 
 	if err = c.cache.Store(reqDTO, respDTO); err != nil {
 		logrus.WithError(err).Error("save file")
@@ -106,3 +267,8 @@ func (c *CacheHandlers) responseHandler(resp *http.Response, ctx *goproxy.ProxyC
 
 	return resp
 }
+
+// analyze runs passive checks, findings go to c.findings
+func (c *CacheHandlers) analyze(req *RequestDTO, resp *ResponseDTO) {
+	c.scanner.Scan(req, resp)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestProxy serves a proxy with its output in a temp dir, scope is set to rules
+func newTestProxy(t *testing.T, rules []*ScopeRule) (*Proxy, *http.Client, func()) {
+	dir, err := ioutil.TempDir("", "anothergoproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, s := options, scope
+	options = Options{OutputPath: dir}
+	scope = &Scope{mux: &sync.RWMutex{}}
+	if err = scope.set(rules); err != nil {
+		t.Fatal(err)
+	}
+	if err = options.MkdirAll(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProxy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(p)
+	proxyURL, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	return p, client, func() {
+		server.Close()
+		p.cacheHandlers.history.Close()
+		options, scope = o, s
+		os.RemoveAll(dir)
+	}
+}
+
+// waitHistory polls for the entry recorded for a response
+func waitHistory(t *testing.T, h *History, match func(e *HistoryEntry) bool) *HistoryEntry {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		entries, _, err := h.Find(HistoryFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if match(e) {
+				return e
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func TestMapRemoteResponseInScope(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mapped " + r.URL.Path))
+	}))
+	defer backend.Close()
+	p, client, done := newTestProxy(t, []*ScopeRule{{Action: ScopeInclude, Host: "*.example.com"}})
+	defer done()
+	err := p.cacheHandlers.maps.Set(nil, []*MapRemoteRule{
+		{ID: "api", Enabled: true, Match: `^http://api\.example\.com/v1/(.*)$`, URL: backend.URL + "/$1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Get("http://api.example.com/v1/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "mapped /users" {
+		t.Fatalf("body %q", body)
+	}
+	e := waitHistory(t, p.cacheHandlers.history, func(e *HistoryEntry) bool { return e.MapRemote != "" && e.Status != 0 })
+	if e == nil {
+		t.Fatal("response of the mapped request is not recorded")
+	}
+	if e.MapRemote != "http://api.example.com/v1/users" || e.Status != http.StatusOK {
+		t.Errorf("entry %+v", e)
+	}
+}
+
+func TestRepeaterPassiveChecks(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}))
+	defer backend.Close()
+	p, _, done := newTestProxy(t, nil)
+	defer done()
+
+	req, err := ParseRawRequest("GET /me HTTP/1.1\nHost: "+backend.Listener.Addr().String()+
+		"\nOrigin: https://evil.com\nReferer: http://"+backend.Listener.Addr().String()+"/\n\n", "http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := p.repeater.Repeat(req, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := p.cacheHandlers.findings.Find(FindingFilter{Type: "cors"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Severity != SeverityHigh || found[0].HistoryID != res.ID {
+		t.Errorf("repeat with a forged origin has findings %+v, want a high one of entry %d", found, res.ID)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redirectTTL       = 30 * time.Second
+	redirectChainsMax = 1000
+)
+
+type RedirectHop struct {
+	Method   string `json:"method"`
+	URL      string `json:"url"`
+	Status   int    `json:"status"`
+	Location string `json:"location"`
+}
+
+type RedirectChain struct {
+	Start string        `json:"start"`
+	Final string        `json:"final"`
+	Hops  []RedirectHop `json:"hops"`
+	Time  time.Time     `json:"time"`
+}
+
+type pendingRedirect struct {
+	hops []RedirectHop
+	time time.Time
+}
+
+// RedirectTracker links requests with 3xx responses which led to them
+type RedirectTracker struct {
+	mux     *sync.Mutex
+	pending map[string]*pendingRedirect
+	chains  []*RedirectChain
+}
+
+func NewRedirectTracker() *RedirectTracker {
+	return &RedirectTracker{
+		mux:     &sync.Mutex{},
+		pending: make(map[string]*pendingRedirect),
+		chains:  make([]*RedirectChain, 0),
+	}
+}
+
+// OnRequest sets RedirectChain if req is the Location of a recent redirect
+func (t *RedirectTracker) OnRequest(req *RequestDTO) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	key := req.URL.String()
+	if p, ok := t.pending[key]; ok {
+		delete(t.pending, key)
+		if time.Since(p.time) < redirectTTL {
+			req.RedirectChain = p.hops
+		}
+	}
+}
+
+func (t *RedirectTracker) OnResponse(req *RequestDTO, resp *http.Response) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	location := resp.Header.Get("Location")
+	if isRedirect(resp.StatusCode) && location != "" {
+		locationURL, err := url.Parse(location)
+		if err != nil {
+			return
+		}
+		hops := make([]RedirectHop, len(req.RedirectChain), len(req.RedirectChain)+1)
+		copy(hops, req.RedirectChain)
+		hops = append(hops, RedirectHop{
+			Method:   req.Method,
+			URL:      req.URL.String(),
+			Status:   resp.StatusCode,
+			Location: location,
+		})
+		for k, p := range t.pending {
+			if time.Since(p.time) > redirectTTL {
+				delete(t.pending, k)
+			}
+		}
+		// requests are normalized before OnRequest, the Location has to be too
+		next := req.URL.ResolveReference(locationURL)
+		next.Fragment = ""
+		normalizeURL(next)
+		t.pending[next.String()] = &pendingRedirect{hops, time.Now()}
+		return
+	}
+	if len(req.RedirectChain) == 0 {
+		return
+	}
+	t.chains = append(t.chains, &RedirectChain{
+		Start: req.RedirectChain[0].URL,
+		Final: req.URL.String(),
+		Hops:  req.RedirectChain,
+		Time:  time.Now(),
+	})
+	if len(t.chains) > redirectChainsMax {
+		t.chains = t.chains[len(t.chains)-redirectChainsMax:]
+	}
+}
+
+// Chains returns finished chains which start or final url contains substr
+func (t *RedirectTracker) Chains(substr string) []*RedirectChain {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	res := make([]*RedirectChain, 0)
+	for _, c := range t.chains {
+		if strings.Contains(c.Start, substr) || strings.Contains(c.Final, substr) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func isRedirect(status int) bool {
+	// 304 Not Modified is not a redirect, it only makes sense for conditional request
+	return status >= 300 && status < 400 && status != http.StatusNotModified
+}
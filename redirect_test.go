@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestRedirectTracker(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		next     string
+		linked   bool
+	}{
+		{"absolute without port", "https://Example.com/next", "https://example.com:443/next", true},
+		{"absolute with port", "https://example.com:443/next", "https://example.com:443/next", true},
+		{"relative", "/next?a=1", "https://example.com:443/next?a=1", true},
+		{"fragment", "/next#top", "https://example.com:443/next", true},
+		{"to http", "http://example.com/next", "http://example.com:80/next", true},
+		{"other port", "https://example.com:8443/next", "https://example.com:443/next", false},
+		{"other url", "/next", "https://example.com:443/other", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewRedirectTracker()
+			first := mitmRequest(http.MethodGet, "https://example.com:443/start")
+			resp := &http.Response{StatusCode: http.StatusFound, Header: http.Header{"Location": {tt.location}}}
+			tracker.OnResponse(first, resp)
+
+			next := mitmRequest(http.MethodGet, tt.next)
+			tracker.OnRequest(next)
+			if linked := len(next.RedirectChain) == 1; linked != tt.linked {
+				t.Fatalf("linked %v, want %v", linked, tt.linked)
+			}
+			if !tt.linked {
+				return
+			}
+			tracker.OnResponse(next, &http.Response{StatusCode: http.StatusOK, Header: http.Header{}})
+			chains := tracker.Chains("")
+			if len(chains) != 1 || chains[0].Start != "https://example.com/start" || chains[0].Hops[0].Location != tt.location {
+				t.Errorf("chains %+v", chains)
+			}
+		})
+	}
+}
+
+func TestRedirectHopJSON(t *testing.T) {
+	data, err := json.Marshal(RedirectChain{Hops: []RedirectHop{{Method: "GET", URL: "https://a.com/", Status: 302, Location: "/b"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"start":"","final":"","hops":[{"method":"GET","url":"https://a.com/","status":302,"location":"/b"}],"time":"0001-01-01T00:00:00Z"}`
+	if string(data) != want {
+		t.Errorf("%s\nwant\n%s", data, want)
+	}
+	// cached requests stored before the tags
+	req := &RequestDTO{}
+	if err = json.Unmarshal([]byte(`{"Method":"GET","URL":{"Scheme":"https","Host":"a.com","Path":"/b"},"RedirectChain":[{"Method":"GET","URL":"https://a.com/","Status":302,"Location":"/b"}]}`), req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.RedirectChain) != 1 || req.RedirectChain[0].Status != 302 || req.RedirectChain[0].Location != "/b" {
+		t.Errorf("old chain %+v", req.RedirectChain)
+	}
+}
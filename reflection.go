@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	ReflectionHTML      = "html"
+	ReflectionAttribute = "attribute"
+	ReflectionScript    = "script"
+	ReflectionJSON      = "json"
+	ReflectionHeader    = "header"
+)
+
+// values shorter than this are everywhere in the pages,
+// path segments are mostly words like "api" or "json" and need to be longer
+const (
+	reflectionMinLength     = 4
+	reflectionMinPathLength = 8
+)
+
+// headers which echo the request by design or hold only well-known values
+var reflectionSkipHeaders = map[string]bool{
+	"Date": true, "Content-Length": true, "Content-Type": true, "Etag": true, "Last-Modified": true, "Vary": true,
+}
+
+// DetectReflections reports request parameter values found in the response body or headers
+func DetectReflections(req *RequestDTO, resp *ResponseDTO) []*Finding {
+	body, err := decodeBody(resp.Header.Get("Content-Encoding"), resp.body)
+	if err != nil {
+		body = resp.body
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	lowerBody := bytes.ToLower(body)
+
+	res := make([]*Finding, 0)
+	seen := make(map[string]bool)
+	for _, param := range req.Params() {
+		param := param
+		if len(param.Value) < reflectionMinLength || param.Kind == ParamPath && len(param.Value) < reflectionMinPathLength {
+			continue
+		}
+		report := func(context, evidence string, escaped bool) {
+			key := param.Kind + "\x00" + param.Name + "\x00" + context
+			if seen[key] {
+				return
+			}
+			seen[key] = true
+			f := &Finding{
+				Type:     "reflection",
+				Severity: reflectionSeverity(context, escaped),
+				Title:    "reflected " + param.Kind + " parameter " + param.Name,
+				Host:     req.URL.Host,
+				Path:     req.URL.Path,
+				URL:      req.URL.String(),
+				Param:    &param,
+				Context:  context,
+				Evidence: evidence,
+			}
+			if escaped {
+				f.Title += " (html escaped)"
+			}
+			if req.history != nil {
+				f.HistoryID = req.history.ID
+			}
+			res = append(res, f)
+		}
+
+		names := make([]string, 0, len(resp.Header))
+		for name := range resp.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if reflectionSkipHeaders[name] {
+				continue
+			}
+			for _, v := range resp.Header[name] {
+				if strings.Contains(v, param.Value) {
+					report(ReflectionHeader, name, false)
+				}
+			}
+		}
+
+		value, escaped := []byte(param.Value), false
+		if bytes.Index(body, value) < 0 {
+			// found only html escaped is much less interesting
+			escapedValue := html.EscapeString(param.Value)
+			if escapedValue == param.Value || bytes.Index(body, []byte(escapedValue)) < 0 {
+				continue
+			}
+			value, escaped = []byte(escapedValue), true
+		}
+		for offset := 0; ; {
+			i := bytes.Index(body[offset:], value)
+			if i < 0 {
+				break
+			}
+			i += offset
+			report(reflectionContext(mediaType, lowerBody, i), snippet(body, i, len(value)), escaped)
+			offset = i + len(value)
+		}
+	}
+	return res
+}
+
+// reflectionContext guesses where in the document offset is
+func reflectionContext(mediaType string, lowerBody []byte, offset int) string {
+	switch {
+	case strings.HasSuffix(mediaType, "json"):
+		return ReflectionJSON
+	case strings.HasSuffix(mediaType, "javascript") || strings.HasSuffix(mediaType, "ecmascript"):
+		return ReflectionScript
+	}
+	before := lowerBody[:offset]
+	if bytes.LastIndex(before, []byte("<script")) > bytes.LastIndex(before, []byte("</script")) {
+		return ReflectionScript
+	}
+	if bytes.LastIndexByte(before, '<') > bytes.LastIndexByte(before, '>') {
+		return ReflectionAttribute
+	}
+	return ReflectionHTML
+}
+
+func reflectionSeverity(context string, escaped bool) string {
+	switch {
+	case escaped && context == ReflectionHTML:
+		return SeverityInfo
+	case context == ReflectionJSON, context == ReflectionHeader, escaped:
+		return SeverityLow
+	}
+	return SeverityMedium
+}
+
+// snippet returns the match with some context around it
+func snippet(body []byte, offset, length int) string {
+	const around = 40
+	start, end := offset-around, offset+length+around
+	if start < 0 {
+		start = 0
+	}
+	if end > len(body) {
+		end = len(body)
+	}
+	return string(body[start:end])
+}
+
+// analysisResponse copies the parts of resp the analyzers read, handlers after them may change headers.
+// The body is decoded and text is converted to utf-8.
+func analysisResponse(resp *ResponseDTO) *ResponseDTO {
+	header := resp.Header.Clone()
+	body := resp.body
+	if decoded, err := decodeBody(header.Get("Content-Encoding"), body); err == nil {
+		header.Del("Content-Encoding")
+		body = decoded
+	}
+	var converted bool
+	if body, converted = toUTF8(textCharset(header.Get("Content-Type"), body), body); converted {
+		if mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type")); err == nil {
+			params["charset"] = "utf-8"
+			header.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+		}
+	}
+	return &ResponseDTO{
+		Response: &http.Response{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Response.Status,
+			Proto:      resp.Proto,
+			Header:     header,
+			Request:    resp.Request,
+		},
+		body: body,
+	}
+}
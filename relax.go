@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/elazarl/goproxy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type RelaxAction string
+
+const (
+	RelaxLeave   RelaxAction = "leave"
+	RelaxDrop    RelaxAction = "drop"
+	RelaxReplace RelaxAction = "replace"
+)
+
+// protections and the headers they are sent in, the first header is set on replace
+var relaxProtections = map[string][]string{
+	"csp":                {"Content-Security-Policy"},
+	"csp-report-only":    {"Content-Security-Policy-Report-Only"},
+	"xss-protection":     {"X-XSS-Protection"},
+	"xfo":                {"X-Frame-Options"},
+	"coop":               {"Cross-Origin-Opener-Policy"},
+	"coep":               {"Cross-Origin-Embedder-Policy"},
+	"corp":               {"Cross-Origin-Resource-Policy"},
+	"permissions-policy": {"Permissions-Policy", "Feature-Policy"},
+	"hsts":               {"Strict-Transport-Security"},
+	// integrity attributes in html, drop only
+	"sri": nil,
+}
+
+// Allow everything https://stackoverflow.com/questions/35978863/allow-all-content-security-policy
+const relaxAllowAllCSP = "default-src *  data: blob: * filesystem: about: ws: wss: 'unsafe-inline' 'unsafe-eval' ; script-src * data: blob: 'unsafe-inline' 'unsafe-eval'; connect-src * data: blob: 'unsafe-inline'; img-src * data: blob: 'unsafe-inline'; frame-src * data: blob: ; style-src * data: blob: 'unsafe-inline'; font-src * data: blob: 'unsafe-inline';"
+
+var sriIntegrityRe = regexp.MustCompile(`(?i)\s+integrity\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'>]+)`)
+
+type Relaxation struct {
+	Action RelaxAction `json:"action"`
+	// header value for replace
+	Value string `json:"value,omitempty"`
+}
+
+type RelaxPolicy struct {
+	Name string `json:"name"`
+	// host and path regexps, empty matches everything
+	Host        string                 `json:"host"`
+	Path        string                 `json:"path"`
+	Enabled     bool                   `json:"enabled"`
+	Protections map[string]*Relaxation `json:"protections"`
+
+	host *regexp.Regexp
+	path *regexp.Regexp
+}
+
+func (p *RelaxPolicy) Match(req *http.Request) bool {
+	return (p.host == nil || p.host.MatchString(req.URL.Host)) &&
+		(p.path == nil || p.path.MatchString(req.URL.Path))
+}
+
+// RelaxPolicies applies the first enabled policy matching the request,
+// protections it doesn't mention are left alone
+type RelaxPolicies struct {
+	mux      *sync.RWMutex
+	Enabled  bool           `json:"enabled"`
+	Policies []*RelaxPolicy `json:"policies"`
+}
+
+// DefaultRelaxPolicy is what the proxy always did: allow-all CSP and no XSS auditor
+func DefaultRelaxPolicy() *RelaxPolicy {
+	return &RelaxPolicy{
+		Name:    "default",
+		Enabled: true,
+		Protections: map[string]*Relaxation{
+			"csp":             {Action: RelaxReplace, Value: relaxAllowAllCSP},
+			"csp-report-only": {Action: RelaxReplace, Value: "default-src blob: *"},
+			"xss-protection":  {Action: RelaxDrop},
+		},
+	}
+}
+
+// NewRelaxPolicies loads policies from a json file, the default policy without one
+func NewRelaxPolicies(filename string) (*RelaxPolicies, error) {
+	p := &RelaxPolicies{mux: &sync.RWMutex{}}
+	c := &RelaxPolicies{Enabled: true, Policies: []*RelaxPolicy{DefaultRelaxPolicy()}}
+	if filename != "" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		c = &RelaxPolicies{Enabled: true}
+		if err = json.Unmarshal(data, c); err != nil {
+			return nil, errors.Wrapf(err, "parse %s", filename)
+		}
+	}
+	if err := p.Set(c.Enabled, c.Policies); err != nil {
+		return nil, errors.Wrapf(err, "relax policies %s", filename)
+	}
+	return p, nil
+}
+
+func (p *RelaxPolicies) Set(enabled bool, policies []*RelaxPolicy) error {
+	for _, policy := range policies {
+		for name, r := range policy.Protections {
+			headers, ok := relaxProtections[name]
+			if !ok {
+				return errors.Errorf("unknown protection %q", name)
+			}
+			if r == nil {
+				return errors.Errorf("no action for %s", name)
+			}
+			switch r.Action {
+			case RelaxLeave, RelaxDrop:
+			case RelaxReplace:
+				if headers == nil {
+					return errors.Errorf("%s can only be dropped", name)
+				}
+			default:
+				return errors.Errorf("unknown action %q of %s", r.Action, name)
+			}
+		}
+		var err error
+		policy.host, policy.path = nil, nil
+		if policy.Host != "" {
+			if policy.host, err = regexp.Compile(policy.Host); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		if policy.Path != "" {
+			if policy.path, err = regexp.Compile(policy.Path); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+	p.mux.Lock()
+	p.Enabled, p.Policies = enabled, policies
+	p.mux.Unlock()
+	return nil
+}
+
+// Toggle switches one policy by name or all of them with an empty name
+func (p *RelaxPolicies) Toggle(name string, enabled bool) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if name == "" {
+		p.Enabled = enabled
+		return nil
+	}
+	for _, policy := range p.Policies {
+		if policy.Name == name {
+			policy.Enabled = enabled
+			return nil
+		}
+	}
+	return errors.Errorf("no policy %q", name)
+}
+
+func (p *RelaxPolicies) Snapshot() RelaxPolicies {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+	policies := make([]*RelaxPolicy, 0, len(p.Policies))
+	for _, policy := range p.Policies {
+		copied := *policy
+		policies = append(policies, &copied)
+	}
+	return RelaxPolicies{Enabled: p.Enabled, Policies: policies}
+}
+
+func (p *RelaxPolicies) policy(req *http.Request) map[string]*Relaxation {
+	p.mux.RLock()
+	defer p.mux.RUnlock()
+	if !p.Enabled {
+		return nil
+	}
+	for _, policy := range p.Policies {
+		if policy.Enabled && policy.Match(req) {
+			return policy.Protections
+		}
+	}
+	return nil
+}
+
+// responseHandler goes after the cache handlers, they record the original headers
+func (p *RelaxPolicies) responseHandler(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+	if resp == nil || ctx.Req == nil {
+		return resp
+	}
+	for name, r := range p.policy(ctx.Req) {
+		headers := relaxProtections[name]
+		switch {
+		case r.Action == RelaxLeave:
+		case name == "sri":
+			relaxSRI(resp)
+		case r.Action == RelaxDrop:
+			for _, h := range headers {
+				resp.Header.Del(h)
+			}
+		case r.Action == RelaxReplace:
+			for _, h := range headers[1:] {
+				resp.Header.Del(h)
+			}
+			resp.Header.Set(headers[0], r.Value)
+		}
+	}
+	return resp
+}
+
+// relaxSRI removes integrity attributes from html so modified scripts and styles still load
+func relaxSRI(resp *http.Response) {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" || resp.Body == nil {
+		return
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		logrus.WithError(err).Error("relax sri: read body")
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return
+	}
+	decoded, err := decodeBody(resp.Header.Get("Content-Encoding"), body)
+	if err != nil || !sriIntegrityRe.Match(decoded) {
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return
+	}
+	body = sriIntegrityRe.ReplaceAll(decoded, nil)
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+}
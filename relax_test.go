@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestRelaxPolicyMITMURL(t *testing.T) {
+	p := &RelaxPolicies{mux: &sync.RWMutex{}}
+	keep := &RelaxPolicy{Name: "keep-csp", Host: `^app\.example\.com$`, Enabled: true, Protections: map[string]*Relaxation{"csp": {Action: RelaxLeave}}}
+	if err := p.Set(true, []*RelaxPolicy{keep, DefaultRelaxPolicy()}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url   string
+		match bool
+	}{
+		{"https://app.example.com:443/", true},
+		{"https://APP.example.com:443/login", true},
+		{"http://app.example.com:80/", true},
+		{"https://www.example.com:443/", false},
+	}
+	for _, tt := range tests {
+		req := mitmRequest(http.MethodGet, tt.url).Request
+		if match := keep.Match(req); match != tt.match {
+			t.Errorf("%s match %v, want %v", tt.url, match, tt.match)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RepeatEdits are applied to the base request, zero fields keep it as is
+type RepeatEdits struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	// empty value removes the header
+	Header map[string]string `json:"header"`
+	Body   *string           `json:"body"`
+}
+
+type RepeatResult struct {
+	// history id of the repeat
+	ID       uint64         `json:"id"`
+	RepeatOf uint64         `json:"repeat_of,omitempty"`
+	Request  *RequestDTO    `json:"request"`
+	Response *ResponseDTO   `json:"response"`
+	Body     string         `json:"body"`
+	Encoding string         `json:"encoding,omitempty"`
+	Timings  HistoryTimings `json:"timings"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// Repeater sends stored requests again through the proxy transport (and its upstream),
+// responses go through the passive checks like proxied ones
+type Repeater struct {
+	tr      http.RoundTripper
+	history *History
+	scanner *PassiveScanner
+}
+
+func NewRepeater(tr http.RoundTripper, history *History, scanner *PassiveScanner) *Repeater {
+	return &Repeater{tr: tr, history: history, scanner: scanner}
+}
+
+// ParseRawRequest reads "GET /path HTTP/1.1\r\nHost: ..." with body, scheme is used for origin-form targets
+func ParseRawRequest(raw, scheme string) (*RequestDTO, error) {
+	// people paste requests with \n line endings
+	if !strings.Contains(raw, "\r\n") {
+		raw = strings.Replace(raw, "\n", "\r\n", -1)
+	}
+	r, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if r.URL.Host == "" {
+		r.URL.Host = r.Host
+	}
+	if r.URL.Scheme == "" {
+		r.URL.Scheme = scheme
+	}
+	if r.URL.Host == "" {
+		return nil, errors.New("no host in the request")
+	}
+	r.RequestURI = ""
+	return NewRequestDTO(r), nil
+}
+
+// Edit returns a modified copy of req
+func (e RepeatEdits) Edit(req *RequestDTO) (*RequestDTO, error) {
+	r := req.HttpRequest()
+	if e.Method != "" {
+		r.Method = e.Method
+	}
+	if e.URL != "" {
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if u.Host != r.URL.Host {
+			r.Host = ""
+		}
+		r.URL = u
+	}
+	for name, value := range e.Header {
+		switch {
+		// http.Request keeps it apart from the other headers
+		case strings.EqualFold(name, "Host"):
+			r.Host = value
+		case value == "":
+			r.Header.Del(name)
+		default:
+			r.Header.Set(name, value)
+		}
+	}
+	if e.Body != nil {
+		r.Body = ioutil.NopCloser(bytes.NewBufferString(*e.Body))
+		r.ContentLength = int64(len(*e.Body))
+		r.TransferEncoding = nil
+		r.Header.Del("Transfer-Encoding")
+		r.Header.Del("Content-Length")
+	}
+	return NewRequestDTO(r), nil
+}
+
+// Repeat sends req and stores it in the history as a repeat of repeatOf (0 for none)
+func (r *Repeater) Repeat(req *RequestDTO, repeatOf uint64) (*RepeatResult, error) {
+	req.cacheMode = CacheModePassthrough
+	entry := NewHistoryEntry(req, 0)
+	entry.RepeatOf = repeatOf
+	if err := r.history.Add(entry, req); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req.history = entry
+	res := &RepeatResult{ID: entry.ID, RepeatOf: repeatOf, Request: req}
+
+	resp, err := r.tr.RoundTrip(req.HttpRequest())
+	wait := milliseconds(time.Since(entry.Time))
+	if err != nil {
+		res.Error, res.Timings = err.Error(), HistoryTimings{Wait: wait, Total: wait}
+		return res, errors.WithStack(r.history.Update(entry, func(e *HistoryEntry) {
+			e.Error, e.Timings = res.Error, res.Timings
+		}))
+	}
+	respDTO, err := NewResponseDTO(resp)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	total := milliseconds(time.Since(entry.Time))
+	res.Response, res.Timings = respDTO, HistoryTimings{Wait: wait, Receive: total - wait, Total: total}
+	res.Body, res.Encoding = harText(respDTO.body)
+	// findings are there when the repeat returns
+	r.scanner.Scan(req, analysisResponse(respDTO))
+	return res, errors.WithStack(r.history.Update(entry, func(e *HistoryEntry) {
+		e.Timings = res.Timings
+		e.Status = resp.StatusCode
+		e.Mime, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		e.ResponseSize = int64(len(respDTO.body))
+	}))
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/elazarl/goproxy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type ReplaceTarget string
+
+const (
+	// "GET /path?query HTTP/1.1"
+	ReplaceRequestLine   ReplaceTarget = "request_line"
+	ReplaceRequestHeader ReplaceTarget = "request_header"
+	ReplaceRequestBody   ReplaceTarget = "request_body"
+	// "200 OK"
+	ReplaceResponseStatus ReplaceTarget = "response_status"
+	ReplaceResponseHeader ReplaceTarget = "response_header"
+	ReplaceResponseBody   ReplaceTarget = "response_body"
+)
+
+func (t ReplaceTarget) Valid() bool {
+	switch t {
+	case ReplaceRequestLine, ReplaceRequestHeader, ReplaceRequestBody,
+		ReplaceResponseStatus, ReplaceResponseHeader, ReplaceResponseBody:
+		return true
+	}
+	return false
+}
+
+func (t ReplaceTarget) Request() bool {
+	return strings.HasPrefix(string(t), "request_")
+}
+
+// ReplaceRule works like in Burp: headers are matched as "Name: value" lines,
+// an empty match adds the replace line and an empty result removes the header
+type ReplaceRule struct {
+	ID      string        `json:"id"`
+	Enabled bool          `json:"enabled"`
+	Target  ReplaceTarget `json:"target"`
+	Match   string        `json:"match"`
+	// $1 and ${name} are expanded for regex rules
+	Replace string `json:"replace"`
+	Regex   bool   `json:"regex"`
+	// same rules as the global scope, empty is everything
+	Scope   []*ScopeRule `json:"scope,omitempty"`
+	Comment string       `json:"comment,omitempty"`
+
+	re    *regexp.Regexp
+	scope *Scope
+}
+
+func (r *ReplaceRule) compile() error {
+	if r.ID == "" {
+		return errors.New("rule without id")
+	}
+	if !r.Target.Valid() {
+		return errors.Errorf("rule %s: unknown target %q", r.ID, r.Target)
+	}
+	isHeader := r.Target == ReplaceRequestHeader || r.Target == ReplaceResponseHeader
+	if r.Match == "" && !isHeader {
+		return errors.Errorf("rule %s: empty match", r.ID)
+	}
+	r.re = nil
+	if r.Regex {
+		var err error
+		if r.re, err = regexp.Compile(r.Match); err != nil {
+			return errors.Wrapf(err, "rule %s", r.ID)
+		}
+	}
+	r.scope = &Scope{mux: &sync.RWMutex{}}
+	return errors.Wrapf(r.scope.set(r.Scope), "rule %s", r.ID)
+}
+
+func (r *ReplaceRule) apply(s []byte) ([]byte, bool) {
+	if r.re != nil {
+		if !r.re.Match(s) {
+			return s, false
+		}
+		return r.re.ReplaceAll(s, []byte(r.Replace)), true
+	}
+	if !bytes.Contains(s, []byte(r.Match)) {
+		return s, false
+	}
+	return bytes.ReplaceAll(s, []byte(r.Match), []byte(r.Replace)), true
+}
+
+// Replacer keeps ordered rules in a json file
+type Replacer struct {
+	mux      *sync.RWMutex
+	rules    []*ReplaceRule
+	filename string
+}
+
+func NewReplacer(filename string) (*Replacer, error) {
+	r := &Replacer{mux: &sync.RWMutex{}, rules: []*ReplaceRule{}, filename: filename}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	rules := make([]*ReplaceRule, 0)
+	if err = json.Unmarshal(data, &rules); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", filename)
+	}
+	if err = r.set(rules); err != nil {
+		return nil, errors.Wrapf(err, "replace rules %s", filename)
+	}
+	return r, nil
+}
+
+func (r *Replacer) set(rules []*ReplaceRule) error {
+	ids := make(map[string]bool)
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return errors.WithStack(err)
+		}
+		if ids[rule.ID] {
+			return errors.Errorf("duplicate rule id %q", rule.ID)
+		}
+		ids[rule.ID] = true
+	}
+	r.mux.Lock()
+	r.rules = rules
+	r.mux.Unlock()
+	return nil
+}
+
+func (r *Replacer) save() error {
+	data, err := json.MarshalIndent(r.Rules(), "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ioutil.WriteFile(r.filename, data, 0644))
+}
+
+// Set replaces all the rules, their order is the order they are applied in
+func (r *Replacer) Set(rules []*ReplaceRule) error {
+	if err := r.set(rules); err != nil {
+		return errors.WithStack(err)
+	}
+	return r.save()
+}
+
+func (r *Replacer) Toggle(id string, enabled bool) error {
+	r.mux.Lock()
+	found := false
+	for _, rule := range r.rules {
+		if rule.ID == id {
+			rule.Enabled, found = enabled, true
+		}
+	}
+	r.mux.Unlock()
+	if !found {
+		return errors.Errorf("no rule %q", id)
+	}
+	return r.save()
+}
+
+func (r *Replacer) Rules() []*ReplaceRule {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	res := make([]*ReplaceRule, 0, len(r.rules))
+	for _, rule := range r.rules {
+		copied := *rule
+		res = append(res, &copied)
+	}
+	return res
+}
+
+func (r *Replacer) matching(req *http.Request, request bool) []*ReplaceRule {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	res := make([]*ReplaceRule, 0)
+	for _, rule := range r.rules {
+		if rule.Enabled && rule.Target.Request() == request && rule.scope.InScope(req.Method, req.URL) {
+			res = append(res, rule)
+		}
+	}
+	return res
+}
+
+// Request applies request rules and returns ids of the applied ones
+func (r *Replacer) Request(req *http.Request) []string {
+	applied := make([]string, 0)
+	for _, rule := range r.matching(req, true) {
+		var ok bool
+		switch rule.Target {
+		case ReplaceRequestLine:
+			ok = replaceRequestLine(req, rule)
+		case ReplaceRequestHeader:
+			lines := append([]string{"Host: " + req.Host}, headerLines(req.Header)...)
+			var h http.Header
+			if h, ok = replaceHeaderLines(lines, rule); ok {
+				req.Host = h.Get("Host")
+				h.Del("Host")
+				req.Header = h
+			}
+		case ReplaceRequestBody:
+			body, err := readBody(&req.Body)
+			if err != nil {
+				logrus.WithError(err).WithField("rule", rule.ID).Error("replace: read request body")
+				continue
+			}
+			if body, ok = rule.apply(body); ok {
+				setRequestBody(req, body)
+			} else {
+				req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+		}
+		if ok {
+			applied = append(applied, rule.ID)
+		}
+	}
+	return applied
+}
+
+// Response applies response rules and returns ids of the applied ones
+func (r *Replacer) Response(req *http.Request, resp *http.Response) []string {
+	applied := make([]string, 0)
+	for _, rule := range r.matching(req, false) {
+		var ok bool
+		switch rule.Target {
+		case ReplaceResponseStatus:
+			ok = replaceStatus(resp, rule)
+		case ReplaceResponseHeader:
+			var h http.Header
+			if h, ok = replaceHeaderLines(headerLines(resp.Header), rule); ok {
+				resp.Header = h
+			}
+		case ReplaceResponseBody:
+			// an event stream doesn't end, reading it would hold the client forever
+			if resp.Body == nil || isEventStream(resp.Header) {
+				continue
+			}
+			body, err := readBody(&resp.Body)
+			if err != nil {
+				logrus.WithError(err).WithField("rule", rule.ID).Error("replace: read response body")
+				continue
+			}
+			decoded, err := decodeBody(resp.Header.Get("Content-Encoding"), body)
+			if err != nil {
+				resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+				continue
+			}
+			if decoded, ok = rule.apply(decoded); !ok {
+				resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+				continue
+			}
+			resp.Header.Del("Content-Encoding")
+			resp.Header.Set("Content-Length", strconv.Itoa(len(decoded)))
+			resp.ContentLength = int64(len(decoded))
+			resp.TransferEncoding = nil
+			resp.Body = ioutil.NopCloser(bytes.NewReader(decoded))
+		}
+		if ok {
+			applied = append(applied, rule.ID)
+		}
+	}
+	return applied
+}
+
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil {
+		return []byte{}, nil
+	}
+	data, err := ioutil.ReadAll(*body)
+	(*body).Close()
+	return data, errors.WithStack(err)
+}
+
+func setRequestBody(req *http.Request, body []byte) {
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.TransferEncoding = nil
+	if req.Header.Get("Content-Length") != "" {
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+}
+
+func headerLines(h http.Header) []string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(h))
+	for _, name := range names {
+		for _, v := range h[name] {
+			lines = append(lines, name+": "+v)
+		}
+	}
+	return lines
+}
+
+func replaceHeaderLines(lines []string, rule *ReplaceRule) (http.Header, bool) {
+	changed := false
+	if rule.Match == "" {
+		lines, changed = append(lines, rule.Replace), true
+	} else {
+		for i, line := range lines {
+			res, ok := rule.apply([]byte(line))
+			if ok {
+				lines[i], changed = string(res), true
+			}
+		}
+	}
+	if !changed {
+		return nil, false
+	}
+	h := http.Header{}
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			// removed
+			continue
+		}
+		h.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return h, true
+}
+
+func replaceRequestLine(req *http.Request, rule *ReplaceRule) bool {
+	line := fmt.Sprintf("%s %s %s", req.Method, req.URL.RequestURI(), req.Proto)
+	res, ok := rule.apply([]byte(line))
+	if !ok {
+		return false
+	}
+	fields := strings.Fields(string(res))
+	if len(fields) < 2 {
+		logrus.WithField("rule", rule.ID).WithField("line", string(res)).Error("replace: bad request line")
+		return false
+	}
+	u, err := url.ParseRequestURI(fields[1])
+	if err != nil {
+		logrus.WithError(err).WithField("rule", rule.ID).Error("replace: bad request uri")
+		return false
+	}
+	req.Method = fields[0]
+	if u.IsAbs() {
+		req.URL = u
+	} else {
+		req.URL.Path, req.URL.RawPath, req.URL.RawQuery = u.Path, u.RawPath, u.RawQuery
+	}
+	return true
+}
+
+func replaceStatus(resp *http.Response, rule *ReplaceRule) bool {
+	status := resp.Status
+	if status == "" {
+		status = fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	res, ok := rule.apply([]byte(status))
+	if !ok {
+		return false
+	}
+	fields := strings.Fields(string(res))
+	if len(fields) == 0 {
+		logrus.WithField("rule", rule.ID).Error("replace: empty status")
+		return false
+	}
+	code, err := strconv.Atoi(fields[0])
+	if err != nil || code < 100 || code > 999 {
+		logrus.WithField("rule", rule.ID).WithField("status", string(res)).Error("replace: bad status")
+		return false
+	}
+	resp.StatusCode, resp.Status = code, string(res)
+	return true
+}
+
+// replaceHandler goes after responseHandler, the cache keeps the original response
+func (c *CacheHandlers) replaceHandler(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+	if resp == nil || ctx.Req == nil {
+		return resp
+	}
+	applied := c.replacer.Response(ctx.Req, resp)
+	if len(applied) == 0 {
+		return resp
+	}
+	if reqDTO, ok := c.sessionStorage.Load(ctx.Session); ok && reqDTO.history != nil {
+		// body rules read the body to the end, the entry is already stored
+		c.history.update(reqDTO.history, func(e *HistoryEntry) { e.Replaced = append(e.Replaced, applied...) })
+	}
+	return resp
+}
@@ -5,24 +5,76 @@ import (
 	"crypto/md5"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 
+	"github.com/elazarl/goproxy"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 type RequestDTO struct {
 	*http.Request
 	body []byte
+	// redirects which led to this request
+	RedirectChain []RedirectHop
+
+	cacheMode CacheMode
+	fromCache bool
+	// file served by a map local rule
+	mapLocal string
+	// body is longer than the record limit, body has only its start
+	truncated bool
+	history   *HistoryEntry
+}
+
+// normalizeURL lowercases the host and drops the default port. goproxy builds MITM urls
+// from the CONNECT authority (host:443), pages, HAR files and Location headers leave it out.
+func normalizeURL(u *url.URL) {
+	u.Host = strings.ToLower(u.Host)
+	port := u.Port()
+	switch {
+	case port == "443" && (u.Scheme == "https" || u.Scheme == "wss"),
+		port == "80" && (u.Scheme == "http" || u.Scheme == "ws"):
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+}
+
+// normalizeHandler runs first, everything after it sees urls as pages have them
+func normalizeHandler(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+	if req.URL != nil {
+		normalizeURL(req.URL)
+	}
+	return req, nil
 }
 
 func NewRequestDTO(req *http.Request) *RequestDTO {
-	body, err := ioutil.ReadAll(req.Body)
+	return NewRequestDTOLimit(req, 0)
+}
+
+// NewRequestDTOLimit keeps at most limit bytes of the body, 0 is no limit.
+// The rest of a longer body is still sent upstream, the dto is truncated.
+func NewRequestDTOLimit(req *http.Request, limit int64) *RequestDTO {
+	normalizeURL(req.URL)
+	var r io.Reader = req.Body
+	if limit > 0 {
+		r = io.LimitReader(req.Body, limit+1)
+	}
+	body, err := ioutil.ReadAll(r)
 	if err != nil {
 		logrus.WithError(err).Error("can't read body")
-		return &RequestDTO{req, []byte("")}
+		return &RequestDTO{Request: req, body: []byte("")}
+	}
+	if limit > 0 && int64(len(body)) > limit {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return &RequestDTO{Request: req, body: body[:limit], truncated: true}
 	}
 	err = req.Body.Close()
 	if err != nil {
@@ -31,25 +83,104 @@ func NewRequestDTO(req *http.Request) *RequestDTO {
 		return nil
 	}
 	req.Body = ioutil.NopCloser(bytes.NewBuffer(body))
-	return &RequestDTO{req, body}
+	return &RequestDTO{Request: req, body: body}
+}
+
+// requestJSON is the stored form, the body is kept apart
+type requestJSON struct {
+	Method           string
+	Host             string
+	RequestURI       string
+	URL              *url.URL
+	Proto            string `json:",omitempty"`
+	ProtoMajor       int    `json:",omitempty"`
+	ProtoMinor       int    `json:",omitempty"`
+	Header           http.Header
+	Trailer          http.Header `json:",omitempty"`
+	ContentLength    int64
+	TransferEncoding []string      `json:",omitempty"`
+	RedirectChain    []RedirectHop `json:",omitempty"`
+	CacheKey         string
 }
 
 func (req RequestDTO) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		Method     string
-		Host       string
-		RequestURI string
-		URL        *url.URL
-		Header     http.Header
-	}{
-		req.Method,
-		req.Host,
-		req.RequestURI,
-		req.URL,
-		req.Header.Clone(),
+	return json.Marshal(requestJSON{
+		Method:           req.Method,
+		Host:             req.Host,
+		RequestURI:       req.RequestURI,
+		URL:              req.URL,
+		Proto:            req.Proto,
+		ProtoMajor:       req.ProtoMajor,
+		ProtoMinor:       req.ProtoMinor,
+		Header:           req.Header.Clone(),
+		Trailer:          req.Trailer.Clone(),
+		ContentLength:    req.ContentLength,
+		TransferEncoding: req.TransferEncoding,
+		RedirectChain:    req.RedirectChain,
+		CacheKey:         req.Hash(),
 	})
 }
 
+func (req *RequestDTO) UnmarshalJSON(b []byte) error {
+	data := requestJSON{ContentLength: -1}
+	err := json.Unmarshal(b, &data)
+	if err != nil {
+		return err
+	}
+	if data.URL == nil {
+		return errors.New("request without url")
+	}
+	if req.Request == nil {
+		req.Request = &http.Request{}
+	}
+	req.Method = data.Method
+	req.Host = data.Host
+	req.RequestURI = data.RequestURI
+	req.URL = data.URL
+	req.Proto = data.Proto
+	req.ProtoMajor = data.ProtoMajor
+	req.ProtoMinor = data.ProtoMinor
+	req.Header = data.Header
+	req.Trailer = data.Trailer
+	req.ContentLength = data.ContentLength
+	if req.ContentLength < 0 {
+		// old cache files
+		req.ContentLength = int64(len(req.body))
+	}
+	req.TransferEncoding = data.TransferEncoding
+	req.RedirectChain = data.RedirectChain
+	req.Body = ioutil.NopCloser(bytes.NewBuffer(req.body))
+	return nil
+}
+
+// HttpRequest returns a copy ready for http.Client
+func (req *RequestDTO) HttpRequest() *http.Request {
+	u := *req.URL
+	r := &http.Request{
+		Method:           req.Method,
+		URL:              &u,
+		Proto:            req.Proto,
+		ProtoMajor:       req.ProtoMajor,
+		ProtoMinor:       req.ProtoMinor,
+		Header:           req.Header.Clone(),
+		Trailer:          req.Trailer.Clone(),
+		Body:             ioutil.NopCloser(bytes.NewReader(req.body)),
+		ContentLength:    int64(len(req.body)),
+		TransferEncoding: req.TransferEncoding,
+		Host:             req.Host,
+	}
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	if r.Proto == "" {
+		r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/1.1", 1, 1
+	}
+	if len(req.body) == 0 {
+		r.Body = http.NoBody
+	}
+	return r
+}
+
 func (req RequestDTO) RawString() string {
 	dump, err := httputil.DumpRequest(req.Request, true)
 	if err != nil {
@@ -59,7 +190,25 @@ func (req RequestDTO) RawString() string {
 	return string(dump)
 }
 
+// Hash is the cache key, see CacheKeyRules
 func (req RequestDTO) Hash() string {
+	return cacheKeyRules.Hash(&req)
+}
+
+// defaultPortHash is the cache key of req recorded before urls were normalized, with :443
+func (req RequestDTO) defaultPortHash() string {
+	if req.URL.Scheme != "https" || req.URL.Port() != "" {
+		return ""
+	}
+	u, r := *req.URL, *req.Request
+	u.Host += ":443"
+	r.URL = &u
+	req.Request = &r
+	return req.Hash()
+}
+
+// legacyHash is the cache key used before CacheKeyRules
+func (req RequestDTO) legacyHash() string {
 	data := fmt.Sprintf(
 		"%s %s %s",
 		req.Method, req.URL.String(), string(req.body),
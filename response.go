@@ -3,8 +3,10 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/pkg/errors"
 )
@@ -12,6 +14,10 @@ import (
 type ResponseDTO struct {
 	*http.Response
 	body []byte
+	// content encoding the stored body was decoded from, see Stored
+	decodedFrom string
+	// body as received when body is decoded, replayed as is
+	encoded []byte
 }
 
 func NewResponseDTO(r *http.Response) (*ResponseDTO, error) {
@@ -41,37 +47,112 @@ func NewResponseDTO(r *http.Response) (*ResponseDTO, error) {
 func (r *ResponseDTO) HttpResponse() *http.Response {
 	resp := &http.Response{}
 	resp.Request = r.Request
+	resp.Proto, resp.ProtoMajor, resp.ProtoMinor = r.Proto, r.ProtoMajor, r.ProtoMinor
+	if resp.Proto == "" {
+		resp.Proto, resp.ProtoMajor, resp.ProtoMinor = "HTTP/1.1", 1, 1
+	}
 	resp.TransferEncoding = r.TransferEncoding
-	resp.Header = make(http.Header)
 	resp.Header = r.Header.Clone()
+	resp.Trailer = r.Trailer.Clone()
 	resp.StatusCode = r.StatusCode
-	resp.Status = http.StatusText(r.StatusCode)
+	resp.Status = r.Status
+	if resp.Status == "" {
+		resp.Status = fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode))
+	}
 	resp.ContentLength = int64(len(r.body))
+	if r.ContentLength < 0 && len(r.TransferEncoding) > 0 {
+		// chunked as the original one
+		resp.ContentLength = -1
+	}
 	resp.Body = ioutil.NopCloser(bytes.NewBuffer(r.body))
 	return resp
 }
 
+// responseJSON is the stored form, the body is kept apart.
+// Status is the code for compatibility with old cache files, StatusLine is "200 OK"
+type responseJSON struct {
+	Status           int
+	StatusLine       string `json:",omitempty"`
+	Proto            string `json:",omitempty"`
+	ProtoMajor       int    `json:",omitempty"`
+	ProtoMinor       int    `json:",omitempty"`
+	Header           http.Header
+	Trailer          http.Header `json:",omitempty"`
+	ContentLength    int64
+	TransferEncoding []string `json:",omitempty"`
+	Uncompressed     bool     `json:",omitempty"`
+	// the stored body is decoded, Header keeps the Content-Encoding it is encoded with on load
+	DecodedFrom string `json:",omitempty"`
+}
+
 func (resp ResponseDTO) MarshalJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		Status int
-		Header http.Header
-	}{
-		resp.StatusCode,
-		resp.Header.Clone(),
+	return json.Marshal(responseJSON{
+		Status:           resp.StatusCode,
+		StatusLine:       resp.Response.Status,
+		Proto:            resp.Proto,
+		ProtoMajor:       resp.ProtoMajor,
+		ProtoMinor:       resp.ProtoMinor,
+		Header:           resp.Header.Clone(),
+		Trailer:          resp.Trailer.Clone(),
+		ContentLength:    resp.ContentLength,
+		TransferEncoding: resp.TransferEncoding,
+		Uncompressed:     resp.Uncompressed,
+		DecodedFrom:      resp.decodedFrom,
 	})
 }
 
 func (resp *ResponseDTO) UnmarshalJSON(b []byte) error {
-	var data struct {
-		Status int
-		Header http.Header
-	}
+	data := responseJSON{ContentLength: -1}
 	err := json.Unmarshal(b, &data)
 	if err != nil {
 		return err
 	}
 	resp.Response.StatusCode = data.Status
+	resp.Response.Status = data.StatusLine
+	resp.Response.Proto = data.Proto
+	resp.Response.ProtoMajor = data.ProtoMajor
+	resp.Response.ProtoMinor = data.ProtoMinor
 	resp.Response.Header = data.Header
+	resp.Response.Trailer = data.Trailer
+	resp.Response.ContentLength = data.ContentLength
+	resp.Response.TransferEncoding = data.TransferEncoding
+	resp.Response.Uncompressed = data.Uncompressed
+	resp.decodedFrom = data.DecodedFrom
 
 	return nil
 }
+
+// Stored is the copy written to the cache, the body is decoded to be readable on disk
+// and the received one is kept in encoded. Bodies which can't be decoded are stored as received.
+func (r *ResponseDTO) Stored() *ResponseDTO {
+	encoding := r.Header.Get("Content-Encoding")
+	if encoding == "" || r.decodedFrom != "" {
+		return r
+	}
+	body, err := decodeBody(encoding, r.body)
+	if err != nil {
+		return r
+	}
+	return &ResponseDTO{Response: r.Response, body: body, decodedFrom: encoding, encoded: r.body}
+}
+
+// Loaded restores the body read from the cache as it was received.
+// Entries stored without the received body have the decoded one encoded again.
+func (r *ResponseDTO) Loaded() error {
+	if r.decodedFrom == "" {
+		return nil
+	}
+	if r.encoded != nil {
+		r.body, r.encoded, r.decodedFrom = r.encoded, nil, ""
+		return nil
+	}
+	body, err := encodeBody(r.decodedFrom, r.body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	r.body, r.decodedFrom = body, ""
+	if r.Header.Get("Content-Length") != "" {
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return nil
+}
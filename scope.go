@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/elazarl/goproxy"
+	"github.com/pkg/errors"
+)
+
+type ScopeAction string
+
+const (
+	ScopeInclude ScopeAction = "include"
+	ScopeExclude ScopeAction = "exclude"
+)
+
+// scope is used by the proxy handlers and the browser
+var scope = &Scope{mux: &sync.RWMutex{}, Rules: []*ScopeRule{}}
+
+// ScopeRule zero fields match everything
+type ScopeRule struct {
+	Action ScopeAction `json:"action"`
+	// http or https
+	Protocol string `json:"protocol,omitempty"`
+	// wildcard like *.example.com
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port,omitempty"`
+	// path regexp
+	Path   string `json:"path,omitempty"`
+	Method string `json:"method,omitempty"`
+
+	host *regexp.Regexp
+	path *regexp.Regexp
+}
+
+func (r *ScopeRule) compile() error {
+	if r.Action != ScopeInclude && r.Action != ScopeExclude {
+		return errors.Errorf("unknown scope action %q", r.Action)
+	}
+	r.host, r.path = nil, nil
+	if r.Host != "" {
+		parts := strings.Split(strings.ToLower(r.Host), "*")
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		r.host = regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+	}
+	if r.Path != "" {
+		var err error
+		if r.path, err = regexp.Compile(r.Path); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// matchHost checks everything known before the tunnel is opened
+func (r *ScopeRule) matchHost(protocol, host string, port int) bool {
+	return (r.Protocol == "" || strings.EqualFold(r.Protocol, protocol)) &&
+		(r.host == nil || r.host.MatchString(strings.ToLower(host))) &&
+		(r.Port == 0 || r.Port == port)
+}
+
+func (r *ScopeRule) Match(method string, u *url.URL) bool {
+	host, port := scopeHostPort(u.Scheme, u.Host)
+	return r.matchHost(u.Scheme, host, port) &&
+		(r.path == nil || r.path.MatchString(u.Path)) &&
+		(r.Method == "" || strings.EqualFold(r.Method, method))
+}
+
+// Scope is decided by the first matching rule, nothing matched is out of scope.
+// No rules at all is everything in scope.
+type Scope struct {
+	mux   *sync.RWMutex
+	Rules []*ScopeRule `json:"rules"`
+
+	filename string
+}
+
+// LoadScope reads rules from a json file and adds include rules for hosts
+func LoadScope(filename string, hosts []string) (*Scope, error) {
+	s := &Scope{mux: &sync.RWMutex{}, filename: filename}
+	rules := make([]*ScopeRule, 0)
+	if filename != "" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		c := &Scope{}
+		if err = json.Unmarshal(data, c); err != nil {
+			return nil, errors.Wrapf(err, "parse %s", filename)
+		}
+		rules = c.Rules
+	}
+	for _, host := range hosts {
+		rules = append(rules, &ScopeRule{Action: ScopeInclude, Host: host})
+	}
+	if err := s.set(rules); err != nil {
+		return nil, errors.Wrapf(err, "scope %s", filename)
+	}
+	return s, nil
+}
+
+func (s *Scope) set(rules []*ScopeRule) error {
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	s.mux.Lock()
+	s.Rules = rules
+	s.mux.Unlock()
+	return nil
+}
+
+// Set replaces the rules and saves them to the file they were loaded from
+func (s *Scope) Set(rules []*ScopeRule) error {
+	if err := s.set(rules); err != nil {
+		return errors.WithStack(err)
+	}
+	if s.filename == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.Snapshot(), "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ioutil.WriteFile(s.filename, data, 0644))
+}
+
+func (s *Scope) Snapshot() Scope {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return Scope{Rules: s.Rules}
+}
+
+func (s *Scope) InScope(method string, u *url.URL) bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if len(s.Rules) == 0 {
+		return true
+	}
+	for _, rule := range s.Rules {
+		if rule.Match(method, u) {
+			return rule.Action == ScopeInclude
+		}
+	}
+	return false
+}
+
+// HostInScope decides whether to MITM a CONNECT to hostport. Excludes narrowed by path
+// or method can't exclude the whole host, they are checked per request inside the tunnel.
+func (s *Scope) HostInScope(protocol, hostport string) bool {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if len(s.Rules) == 0 {
+		return true
+	}
+	host, port := scopeHostPort(protocol, hostport)
+	for _, rule := range s.Rules {
+		if !rule.matchHost(protocol, host, port) {
+			continue
+		}
+		if rule.Action == ScopeInclude {
+			return true
+		}
+		if rule.path == nil && rule.Method == "" {
+			return false
+		}
+	}
+	return false
+}
+
+// ReqCondition for goproxy handlers, out of scope requests skip them.
+// A request sent elsewhere by map remote is checked by the url it had.
+func (s *Scope) ReqCondition() goproxy.ReqConditionFunc {
+	return func(req *http.Request, ctx *goproxy.ProxyCtx) bool {
+		if req == nil {
+			return false
+		}
+		u := req.URL
+		if origin, ok := ctx.UserData.(*mapRemoteOrigin); ok {
+			u = origin.url
+		}
+		return s.InScope(req.Method, u)
+	}
+}
+
+func scopeHostPort(protocol, hostport string) (string, int) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+		switch protocol {
+		case "https", "wss":
+			return host, 443
+		default:
+			return host, 80
+		}
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func TestScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []*ScopeRule
+		method  string
+		url     string
+		inScope bool
+	}{
+		{"no rules", nil, "GET", "https://a.com/", true},
+		{"host wildcard", []*ScopeRule{{Action: ScopeInclude, Host: "*.a.com"}}, "GET", "https://api.a.com/", true},
+		{"host wildcard needs a dot", []*ScopeRule{{Action: ScopeInclude, Host: "*.a.com"}}, "GET", "https://evila.com/", false},
+		{"host case", []*ScopeRule{{Action: ScopeInclude, Host: "API.A.com"}}, "GET", "https://api.a.COM/", true},
+		{"host is not a regexp", []*ScopeRule{{Action: ScopeInclude, Host: "a.com"}}, "GET", "https://abcom/", false},
+		{"nothing matched", []*ScopeRule{{Action: ScopeInclude, Host: "a.com"}}, "GET", "https://b.com/", false},
+		{"protocol", []*ScopeRule{{Action: ScopeInclude, Protocol: "https"}}, "GET", "http://a.com/", false},
+		{"default https port", []*ScopeRule{{Action: ScopeInclude, Port: 443}}, "GET", "https://a.com/", true},
+		{"default http port", []*ScopeRule{{Action: ScopeInclude, Port: 443}}, "GET", "http://a.com/", false},
+		{"explicit port", []*ScopeRule{{Action: ScopeInclude, Host: "a.com", Port: 8443}}, "GET", "https://a.com:8443/", true},
+		{"path", []*ScopeRule{{Action: ScopeInclude, Path: "^/api/"}}, "GET", "https://a.com/api/me", true},
+		{"path anchored", []*ScopeRule{{Action: ScopeInclude, Path: "^/api/"}}, "GET", "https://a.com/static/api/", false},
+		{"method", []*ScopeRule{{Action: ScopeInclude, Method: "post"}}, "GET", "https://a.com/", false},
+		{
+			"first matching rule wins",
+			[]*ScopeRule{{Action: ScopeExclude, Host: "a.com", Path: `\.js$`}, {Action: ScopeInclude, Host: "a.com"}},
+			"GET", "https://a.com/app.js", false,
+		},
+		{
+			"exclude narrowed by path",
+			[]*ScopeRule{{Action: ScopeExclude, Host: "a.com", Path: `\.js$`}, {Action: ScopeInclude, Host: "a.com"}},
+			"GET", "https://a.com/index.html", true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Scope{mux: &sync.RWMutex{}}
+			if err := s.set(tt.rules); err != nil {
+				t.Fatal(err)
+			}
+			u, _ := url.Parse(tt.url)
+			if got := s.InScope(tt.method, u); got != tt.inScope {
+				t.Errorf("InScope(%s %s) = %v", tt.method, tt.url, got)
+			}
+		})
+	}
+}
+
+func TestScopeHostInScope(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []*ScopeRule
+		protocol string
+		hostport string
+		inScope  bool
+	}{
+		{"no rules", nil, "https", "a.com:443", true},
+		{"host", []*ScopeRule{{Action: ScopeInclude, Host: "*.a.com"}}, "https", "api.a.com:443", true},
+		{"other host", []*ScopeRule{{Action: ScopeInclude, Host: "*.a.com"}}, "https", "b.com:443", false},
+		{"no port", []*ScopeRule{{Action: ScopeInclude, Port: 443}}, "https", "a.com", true},
+		{"protocol", []*ScopeRule{{Action: ScopeInclude, Protocol: "http"}}, "https", "a.com:443", false},
+		{"excluded host", []*ScopeRule{{Action: ScopeExclude, Host: "a.com"}, {Action: ScopeInclude}}, "https", "a.com:443", false},
+		{
+			// checked per request inside the tunnel
+			"exclude narrowed by path",
+			[]*ScopeRule{{Action: ScopeExclude, Host: "a.com", Path: "^/static/"}, {Action: ScopeInclude, Host: "a.com"}},
+			"https", "a.com:443", true,
+		},
+		{
+			"exclude narrowed by method",
+			[]*ScopeRule{{Action: ScopeExclude, Host: "a.com", Method: "OPTIONS"}, {Action: ScopeInclude}},
+			"https", "a.com:443", true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Scope{mux: &sync.RWMutex{}}
+			if err := s.set(tt.rules); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.HostInScope(tt.protocol, tt.hostport); got != tt.inScope {
+				t.Errorf("HostInScope(%s, %s) = %v", tt.protocol, tt.hostport, got)
+			}
+		})
+	}
+}
+
+func TestScopeRuleCompile(t *testing.T) {
+	for _, rule := range []*ScopeRule{{Action: "allow"}, {Action: ScopeInclude, Path: "("}} {
+		if err := rule.compile(); err == nil {
+			t.Errorf("%+v compiled", rule)
+		}
+	}
+}
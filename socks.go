@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// https://tools.ietf.org/html/rfc1928, user/password auth from https://tools.ietf.org/html/rfc1929
+const (
+	socks5Version     = 5
+	socksAuthVersion  = 1
+	socksNoAuth       = 0
+	socksUserPassAuth = 2
+	socksNoMethods    = 0xff
+	socksConnect      = 1
+	socksIPv4         = 1
+	socksDomain       = 3
+	socksIPv6         = 4
+
+	socksSucceeded           = 0
+	socksCommandNotSupported = 7
+	socksAddrNotSupported    = 8
+)
+
+// SOCKS5Server accepts CONNECT requests and passes the connections to the proxy
+type SOCKS5Server struct {
+	proxy *Proxy
+	// no auth if empty
+	user, pass string
+}
+
+func NewSOCKS5Server(proxy *Proxy, user, pass string) *SOCKS5Server {
+	return &SOCKS5Server{proxy: proxy, user: user, pass: pass}
+}
+
+func (s *SOCKS5Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return s.Serve(l)
+}
+
+func (s *SOCKS5Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		go func() {
+			target, err := s.handshake(conn)
+			if err != nil {
+				logrus.WithError(err).WithField("client", conn.RemoteAddr().String()).Warn("socks5")
+				conn.Close()
+				return
+			}
+			s.proxy.ServeConn(conn, target)
+		}()
+	}
+}
+
+// handshake returns the requested host:port, the client is told it is connected
+func (s *SOCKS5Server) handshake(conn net.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if buf[0] != socks5Version {
+		return "", errors.Errorf("unsupported socks version %d", buf[0])
+	}
+	methods := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", errors.WithStack(err)
+	}
+	method := byte(socksNoAuth)
+	if s.user != "" {
+		method = socksUserPassAuth
+	}
+	if bytes.IndexByte(methods, method) < 0 {
+		conn.Write([]byte{socks5Version, socksNoMethods})
+		return "", errors.New("no acceptable auth method")
+	}
+	if _, err := conn.Write([]byte{socks5Version, method}); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if method == socksUserPassAuth {
+		if err := s.auth(conn); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return "", errors.WithStack(err)
+	}
+	cmd, atyp := buf[1], buf[3]
+	var host string
+	switch atyp {
+	case socksIPv4, socksIPv6:
+		ip := make(net.IP, 4)
+		if atyp == socksIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", errors.WithStack(err)
+		}
+		host = ip.String()
+	case socksDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return "", errors.WithStack(err)
+		}
+		domain := make([]byte, buf[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", errors.WithStack(err)
+		}
+		host = string(domain)
+	default:
+		socksReply(conn, socksAddrNotSupported)
+		return "", errors.Errorf("unsupported address type %d", atyp)
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return "", errors.WithStack(err)
+	}
+	port := binary.BigEndian.Uint16(buf[:2])
+	if cmd != socksConnect {
+		socksReply(conn, socksCommandNotSupported)
+		return "", errors.Errorf("unsupported command %d", cmd)
+	}
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+func (s *SOCKS5Server) auth(conn net.Conn) error {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return errors.WithStack(err)
+	}
+	if buf[0] != socksAuthVersion {
+		return errors.Errorf("unsupported auth version %d", buf[0])
+	}
+	user := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return errors.WithStack(err)
+	}
+	pass := make([]byte, buf[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return errors.WithStack(err)
+	}
+	if subtle.ConstantTimeCompare(user, []byte(s.user)) != 1 || subtle.ConstantTimeCompare(pass, []byte(s.pass)) != 1 {
+		conn.Write([]byte{socksAuthVersion, 1})
+		return errors.Errorf("bad credentials for %q", user)
+	}
+	_, err := conn.Write([]byte{socksAuthVersion, 0})
+	return errors.WithStack(err)
+}
+
+// socksReply has a zero bound address, clients don't use it for CONNECT
+func socksReply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socks5Version, status, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return errors.WithStack(err)
+}
+
+// how long a client is given to speak first before the connection is tunneled as is
+const sniffTimeout = 3 * time.Second
+
+var httpMethodPrefixes = [][]byte{
+	[]byte("GET "), []byte("POST"), []byte("HEAD"), []byte("PUT "), []byte("DELE"),
+	[]byte("OPTI"), []byte("PATC"), []byte("TRAC"), []byte("CONN"),
+}
+
+// ServeConn runs a connection to target through the handlers: tls is mitm'd like a CONNECT,
+// plain http is proxied request by request, anything else is tunneled untouched.
+// An empty target is taken from SNI or the Host header, a SNI name is used instead of an ip target.
+func (p *Proxy) ServeConn(conn net.Conn, target string) {
+	br := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	head, _ := br.Peek(4)
+	conn.SetReadDeadline(time.Time{})
+
+	switch {
+	case len(head) > 0 && head[0] == 0x16:
+		// tls handshake record
+		conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+		name, r := sniffServerName(br)
+		conn.SetReadDeadline(time.Time{})
+		conn = &peekedConn{Conn: conn, r: r}
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			host, port = "", "443"
+		}
+		if name != "" && (host == "" || net.ParseIP(host) != nil) {
+			target = net.JoinHostPort(name, port)
+		}
+		if target == "" {
+			logrus.WithField("client", conn.RemoteAddr().String()).Warn("tls without sni, no target")
+			conn.Close()
+			return
+		}
+		p.serveConnect(conn, target)
+	case len(head) == 4 && isHTTPMethodPrefix(head):
+		p.serveHTTPConn(&peekedConn{Conn: conn, r: br}, target)
+	case target != "":
+		p.tunnel(&peekedConn{Conn: conn, r: br}, target)
+	default:
+		logrus.WithField("client", conn.RemoteAddr().String()).Warn("neither tls nor http, no target")
+		conn.Close()
+	}
+}
+
+func isHTTPMethodPrefix(head []byte) bool {
+	for _, prefix := range httpMethodPrefixes {
+		if bytes.Equal(head, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveConnect hands conn to goproxy as a hijacked CONNECT
+func (p *Proxy) serveConnect(conn net.Conn, target string) {
+	req := &http.Request{
+		Method:     http.MethodConnect,
+		URL:        &url.URL{Host: target},
+		Host:       target,
+		Header:     make(http.Header),
+		RemoteAddr: conn.RemoteAddr().String(),
+		Proto:      "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1,
+	}
+	p.ProxyHttpServer.ServeHTTP(&connectWriter{conn: &connectedConn{Conn: conn}}, req)
+}
+
+// serveHTTPConn proxies plain http requests read from conn, the Host header names the site
+func (p *Proxy) serveHTTPConn(conn net.Conn, target string) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if host == "" {
+			host = target
+		} else if _, _, err := net.SplitHostPort(host); err != nil {
+			if _, port, _ := net.SplitHostPort(target); port != "80" && port != "" {
+				host = net.JoinHostPort(host, port)
+			}
+		}
+		if host == "" {
+			http.Error(w, "anothergoproxy: no Host header", http.StatusBadRequest)
+			return
+		}
+		r.URL.Scheme, r.URL.Host = "http", host
+		p.ServeHTTP(w, r)
+	})
+	l := newConnListener(conn)
+	server := &http.Server{Handler: handler, ConnState: func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed || state == http.StateHijacked {
+			l.Close()
+		}
+	}}
+	server.Serve(l)
+}
+
+// tunnel copies conn to target and back
+func (p *Proxy) tunnel(conn net.Conn, target string) {
+	// neither tls nor http, only routes without a protocol match
+	remote, err := p.router.DialProtocol("tcp", "tcp", target)
+	if err != nil {
+		logrus.WithError(err).WithField("target", target).Warn("tunnel")
+		conn.Close()
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		// the other direction is done too
+		dst.Close()
+		src.Close()
+	}
+	go pipe(remote, conn)
+	go pipe(conn, remote)
+	wg.Wait()
+}
+
+// peekedConn reads what was peeked first
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// connectedConn drops the "200 OK" goproxy answers a CONNECT with, the client
+// of a socks or transparent connection is connected already. An error answer closes it.
+type connectedConn struct {
+	net.Conn
+	answered bool
+}
+
+var connectOK = []byte("HTTP/1.0 200 OK\r\n\r\n")
+
+func (c *connectedConn) Write(p []byte) (int, error) {
+	if !c.answered {
+		c.answered = true
+		if bytes.Equal(p, connectOK) {
+			return len(p), nil
+		}
+		if bytes.HasPrefix(p, []byte("HTTP/1.")) {
+			c.Conn.Close()
+			return 0, errors.New("connect failed: " + string(bytes.SplitN(p, []byte("\r\n"), 2)[0]))
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+// connectWriter is the ResponseWriter of a made up CONNECT, goproxy only hijacks it
+type connectWriter struct {
+	conn   net.Conn
+	header http.Header
+}
+
+func (w *connectWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *connectWriter) Write(p []byte) (int, error) {
+	return w.conn.Write(p)
+}
+
+func (w *connectWriter) WriteHeader(int) {}
+
+func (w *connectWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
+
+// connListener serves a single connection with http.Server
+type connListener struct {
+	conn   chan net.Conn
+	closed chan bool
+	once   *sync.Once
+	addr   net.Addr
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	l := &connListener{conn: make(chan net.Conn, 1), closed: make(chan bool), once: &sync.Once{}, addr: conn.LocalAddr()}
+	l.conn <- conn
+	return l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conn:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
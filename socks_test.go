@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptConn reads a scripted client and records what the server answers
+type scriptConn struct {
+	net.Conn
+	in  *bytes.Reader
+	out bytes.Buffer
+}
+
+func (c *scriptConn) Read(p []byte) (int, error)         { return c.in.Read(p) }
+func (c *scriptConn) Write(p []byte) (int, error)        { return c.out.Write(p) }
+func (c *scriptConn) SetDeadline(t time.Time) error      { return nil }
+func (c *scriptConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *scriptConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *scriptConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func socksGreeting(methods ...byte) []byte {
+	return append([]byte{socks5Version, byte(len(methods))}, methods...)
+}
+
+func socksAuthRequest(user, pass string) []byte {
+	b := append([]byte{socksAuthVersion, byte(len(user))}, user...)
+	return append(append(b, byte(len(pass))), pass...)
+}
+
+func socksRequest(cmd, atyp byte, addr []byte, port uint16) []byte {
+	b := []byte{socks5Version, cmd, 0, atyp}
+	if atyp == socksDomain {
+		b = append(b, byte(len(addr)))
+	}
+	b = append(b, addr...)
+	return append(b, byte(port>>8), byte(port))
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestSOCKS5Handshake(t *testing.T) {
+	allMethods := make([]byte, 255)
+	for i := range allMethods {
+		allMethods[i] = byte(i)
+	}
+	longDomain := strings.Repeat("a", 255)
+	longUser, longPass := strings.Repeat("u", 255), strings.Repeat("p", 255)
+
+	tests := []struct {
+		name       string
+		user, pass string
+		client     []byte
+		target     string
+		err        bool
+		// first bytes answered, the method selection
+		reply []byte
+	}{
+		{
+			name:   "ipv4",
+			client: concat(socksGreeting(socksNoAuth), socksRequest(socksConnect, socksIPv4, []byte{127, 0, 0, 1}, 443)),
+			target: "127.0.0.1:443",
+			reply:  []byte{socks5Version, socksNoAuth},
+		},
+		{
+			name:   "ipv6",
+			client: concat(socksGreeting(socksNoAuth), socksRequest(socksConnect, socksIPv6, net.ParseIP("::1"), 8080)),
+			target: "[::1]:8080",
+		},
+		{
+			name:   "max methods and domain",
+			client: concat(socksGreeting(allMethods...), socksRequest(socksConnect, socksDomain, []byte(longDomain), 80)),
+			target: longDomain + ":80",
+			reply:  []byte{socks5Version, socksNoAuth},
+		},
+		{
+			name:   "empty domain",
+			client: concat(socksGreeting(socksNoAuth), socksRequest(socksConnect, socksDomain, nil, 80)),
+			target: ":80",
+		},
+		{
+			name: "user pass",
+			user: "user", pass: "pass",
+			client: concat(socksGreeting(socksNoAuth, socksUserPassAuth), socksAuthRequest("user", "pass"), socksRequest(socksConnect, socksDomain, []byte("example.com"), 443)),
+			target: "example.com:443",
+			reply:  []byte{socks5Version, socksUserPassAuth, socksAuthVersion, 0},
+		},
+		{
+			name: "max user pass",
+			user: longUser, pass: longPass,
+			client: concat(socksGreeting(socksUserPassAuth), socksAuthRequest(longUser, longPass), socksRequest(socksConnect, socksIPv4, []byte{10, 0, 0, 1}, 22)),
+			target: "10.0.0.1:22",
+		},
+		{
+			name: "bad credentials",
+			user: "user", pass: "pass",
+			client: concat(socksGreeting(socksUserPassAuth), socksAuthRequest("user", "nope")),
+			err:    true,
+			reply:  []byte{socks5Version, socksUserPassAuth, socksAuthVersion, 1},
+		},
+		{
+			name: "auth required",
+			user: "user", pass: "pass",
+			client: socksGreeting(socksNoAuth),
+			err:    true,
+			reply:  []byte{socks5Version, socksNoMethods},
+		},
+		{
+			name:   "socks4",
+			client: []byte{4, 1, 0, 80, 127, 0, 0, 1, 0},
+			err:    true,
+		},
+		{
+			name:   "bind",
+			client: concat(socksGreeting(socksNoAuth), socksRequest(2, socksIPv4, []byte{127, 0, 0, 1}, 80)),
+			err:    true,
+			reply:  []byte{socks5Version, socksNoAuth, socks5Version, socksCommandNotSupported},
+		},
+		{
+			name:   "unknown address type",
+			client: concat(socksGreeting(socksNoAuth), []byte{socks5Version, socksConnect, 0, 9}),
+			err:    true,
+			reply:  []byte{socks5Version, socksNoAuth, socks5Version, socksAddrNotSupported},
+		},
+		{
+			name:   "short methods",
+			client: []byte{socks5Version, 255, socksNoAuth},
+			err:    true,
+		},
+		{
+			name:   "short domain",
+			client: concat(socksGreeting(socksNoAuth), []byte{socks5Version, socksConnect, 0, socksDomain, 255}, []byte("example.com")),
+			err:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &scriptConn{in: bytes.NewReader(tt.client)}
+			target, err := NewSOCKS5Server(nil, tt.user, tt.pass).handshake(conn)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if target != tt.target {
+				t.Errorf("target = %q, want %q", target, tt.target)
+			}
+			out := conn.out.Bytes()
+			if tt.reply != nil && !bytes.HasPrefix(out, tt.reply) {
+				t.Errorf("reply = %v, want prefix %v", out, tt.reply)
+			}
+			if !tt.err {
+				status := out[len(out)-10:]
+				if status[1] != socksSucceeded || binary.BigEndian.Uint16(status[8:]) != 0 {
+					t.Errorf("connect reply = %v", status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// bodies of known length below this are buffered like before
+const streamMinLength = 1 << 20
+
+func isEventStream(h http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// streamed responses go to the client as they come and are recorded on the way
+func isStreamed(resp *http.Response) bool {
+	return isEventStream(resp.Header) || resp.ContentLength < 0 || resp.ContentLength > streamMinLength
+}
+
+func streamFilename(id uint64, ext string) string {
+	return filepath.Join(options.StreamsPath(), fmt.Sprintf("%d.%s", id, ext))
+}
+
+// streamBody tees what the client reads to record, recording stops past limit
+type streamBody struct {
+	io.ReadCloser
+	record func(p []byte) error
+	// 0 is no limit
+	limit     int64
+	n         int64
+	truncated bool
+	once      *sync.Once
+	done      func(b *streamBody, complete bool)
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.truncated {
+		if b.limit > 0 && b.n+int64(n) > b.limit {
+			b.truncated = true
+		} else if werr := b.record(p[:n]); werr != nil {
+			logrus.WithError(werr).Error("stream record")
+			b.truncated = true
+		}
+		b.n += int64(n)
+	}
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b, true) })
+	}
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b, false) })
+	return err
+}
+
+// streamResponse records resp while the client reads it, event streams event by event
+// to <id>.events.jsonl, other bodies to <id>.body and then to the cache
+func (c *CacheHandlers) streamResponse(req *RequestDTO, resp *http.Response) {
+	e := req.history
+	if e == nil || resp.Body == nil {
+		return
+	}
+	c.history.update(e, func(e *HistoryEntry) { e.Streamed = true })
+	if isEventStream(resp.Header) {
+		rec, err := NewSSERecorder(streamFilename(e.ID, "events.jsonl"))
+		if err != nil {
+			logrus.WithError(err).Error("sse recorder")
+			return
+		}
+		resp.Body = &streamBody{ReadCloser: resp.Body, record: rec.Write, once: &sync.Once{}, done: func(b *streamBody, complete bool) {
+			if err := rec.Close(); err != nil {
+				logrus.WithError(err).Error("sse recorder")
+			}
+			c.history.update(e, func(e *HistoryEntry) { e.Events = rec.Count() })
+		}}
+		return
+	}
+
+	filename := streamFilename(e.ID, "body")
+	file, err := os.Create(filename)
+	if err != nil {
+		logrus.WithError(err).Error("stream record")
+		return
+	}
+	// handlers after this one change headers
+	snapshot := *resp
+	snapshot.Header = resp.Header.Clone()
+	record := func(p []byte) error {
+		_, err := file.Write(p)
+		return err
+	}
+	resp.Body = &streamBody{ReadCloser: resp.Body, record: record, limit: options.RecordLimit, once: &sync.Once{}, done: func(b *streamBody, complete bool) {
+		file.Close()
+		c.history.update(e, func(e *HistoryEntry) { e.Truncated = e.Truncated || b.truncated })
+		if !complete || b.truncated {
+			// the partial recording stays in the file
+			logrus.Printf("[%d] <-- recorded %d bytes of %s (complete %v)", e.Session, b.n, urlColor(e.URL), complete && !b.truncated)
+			return
+		}
+		go func() {
+			body, err := ioutil.ReadFile(filename)
+			if err != nil {
+				logrus.WithError(err).Error("stream record")
+				return
+			}
+			os.Remove(filename)
+			respDTO := &ResponseDTO{Response: &snapshot, body: body}
+			c.analyze(req, analysisResponse(respDTO))
+			if !req.cacheMode.Record() {
+				return
+			}
+			if err := c.cache.Store(req, respDTO); err != nil {
+				logrus.WithError(err).Error("save file")
+			}
+		}()
+	}}
+}
+
+type SSEEvent struct {
+	Index int       `json:"index"`
+	Time  time.Time `json:"time"`
+	ID    string    `json:"id,omitempty"`
+	Event string    `json:"event,omitempty"`
+	Data  string    `json:"data"`
+	Retry string    `json:"retry,omitempty"`
+}
+
+// SSERecorder parses the event stream as it passes and appends each event to a jsonl file
+type SSERecorder struct {
+	file  *os.File
+	enc   *json.Encoder
+	line  []byte
+	event *SSEEvent
+	data  []string
+	count int
+}
+
+func NewSSERecorder(filename string) (*SSERecorder, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &SSERecorder{file: file, enc: json.NewEncoder(file), event: &SSEEvent{}}, nil
+}
+
+func (r *SSERecorder) Write(p []byte) error {
+	r.line = append(r.line, p...)
+	for {
+		i := bytes.IndexAny(r.line, "\r\n")
+		if i < 0 {
+			return nil
+		}
+		if r.line[i] == '\r' && i+1 == len(r.line) {
+			// \r\n may be split between reads
+			return nil
+		}
+		line := string(r.line[:i])
+		if r.line[i] == '\r' && r.line[i+1] == '\n' {
+			i++
+		}
+		r.line = r.line[i+1:]
+		if err := r.field(line); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
+
+// field handles one line of https://html.spec.whatwg.org/multipage/server-sent-events.html
+func (r *SSERecorder) field(line string) error {
+	if line == "" {
+		return r.dispatch()
+	}
+	if strings.HasPrefix(line, ":") {
+		return nil
+	}
+	name, value := line, ""
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		name, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+	}
+	switch name {
+	case "data":
+		r.data = append(r.data, value)
+	case "event":
+		r.event.Event = value
+	case "id":
+		r.event.ID = value
+	case "retry":
+		r.event.Retry = value
+	}
+	return nil
+}
+
+// dispatch records the event, browsers drop events without data lines
+func (r *SSERecorder) dispatch() error {
+	if len(r.data) == 0 {
+		r.event = &SSEEvent{}
+		return nil
+	}
+	ev := r.event
+	ev.Index, ev.Time, ev.Data = r.count, time.Now(), strings.Join(r.data, "\n")
+	r.event, r.data = &SSEEvent{}, nil
+	r.count++
+	return r.enc.Encode(ev)
+}
+
+func (r *SSERecorder) Count() int {
+	return r.count
+}
+
+func (r *SSERecorder) Close() error {
+	return r.file.Close()
+}
+
+// LoadSSEEvents reads events recorded for a history entry
+func LoadSSEEvents(id uint64) ([]*SSEEvent, error) {
+	data, err := ioutil.ReadFile(streamFilename(id, "events.jsonl"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	res := make([]*SSEEvent, 0)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		ev := &SSEEvent{}
+		if err := dec.Decode(ev); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		res = append(res, ev)
+	}
+	return res, nil
+}
+
+// flushWriter sends events to plain http clients as they come, net/http would hold
+// them in its buffer. Tunneled https is written to the connection directly.
+type flushWriter struct {
+	http.ResponseWriter
+	flush bool
+}
+
+func (w *flushWriter) WriteHeader(status int) {
+	w.flush = isEventStream(w.Header())
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *flushWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if w.flush {
+		if f, ok := w.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return n, err
+}
+
+// Hijack is used for CONNECT
+func (w *flushWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	return h.Hijack()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSSERecorder(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		events []SSEEvent
+	}{
+		{
+			name:   "data lines",
+			chunks: []string{"data: a\ndata: b\n\n"},
+			events: []SSEEvent{{Data: "a\nb"}},
+		},
+		{
+			name:   "fields",
+			chunks: []string{"id: 1\nevent: update\nretry: 1000\ndata:{\"a\":1}\n\n"},
+			events: []SSEEvent{{ID: "1", Event: "update", Retry: "1000", Data: `{"a":1}`}},
+		},
+		{
+			name:   "only the first space is stripped",
+			chunks: []string{"data:  a \n\n"},
+			events: []SSEEvent{{Data: " a "}},
+		},
+		{
+			name:   "comments and unknown fields",
+			chunks: []string{": ping\n\nfoo: bar\ndata\n\n"},
+			events: []SSEEvent{{Data: ""}},
+		},
+		{
+			name:   "crlf and cr",
+			chunks: []string{"data: a\r\n\r\ndata: b\r\rdata: c\n\n"},
+			events: []SSEEvent{{Data: "a"}, {Data: "b"}, {Data: "c"}},
+		},
+		{
+			name:   "split between reads",
+			chunks: []string{"da", "ta: a\r", "\n", "\r", "\nevent: x\ndata: b", "\n", "\n"},
+			events: []SSEEvent{{Data: "a"}, {Event: "x", Data: "b"}},
+		},
+		{
+			name:   "event without data",
+			chunks: []string{"event: x\n\ndata: a\n\n"},
+			events: []SSEEvent{{Data: "a"}},
+		},
+		{
+			name:   "unfinished event",
+			chunks: []string{"data: a\n\ndata: b\n"},
+			events: []SSEEvent{{Data: "a"}},
+		},
+	}
+	dir, err := ioutil.TempDir("", "anothergoproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(dir, string(rune('a'+i))+".jsonl")
+			r, err := NewSSERecorder(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, chunk := range tt.chunks {
+				if err := r.Write([]byte(chunk)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r.Close()
+			if r.Count() != len(tt.events) {
+				t.Errorf("count %d, want %d", r.Count(), len(tt.events))
+			}
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]SSEEvent, 0)
+			dec := json.NewDecoder(bytes.NewReader(data))
+			for dec.More() {
+				ev := SSEEvent{}
+				if err := dec.Decode(&ev); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, ev)
+			}
+			if len(got) != len(tt.events) {
+				t.Fatalf("%d events, want %d", len(got), len(tt.events))
+			}
+			for j, want := range tt.events {
+				want.Index, want.Time = j, got[j].Time
+				if got[j] != want {
+					t.Errorf("event %d = %+v, want %+v", j, got[j], want)
+				}
+			}
+		})
+	}
+}
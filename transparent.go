@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ListenTransparent accepts connections redirected to the proxy by dns or iptables,
+// clients don't know about the proxy so the target is taken from SNI or the Host header.
+// TLS targets are on port 443, plain http ones on the Host header port.
+func (p *Proxy) ListenTransparent(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return p.ServeTransparent(l)
+}
+
+func (p *Proxy) ServeTransparent(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		go p.ServeConn(conn, "")
+	}
+}
+
+var errSniffed = errors.New("client hello sniffed")
+
+// sniffServerName reads the ClientHello from r and returns its SNI name and a reader
+// which starts with the bytes read, the handshake is done again by the mitm
+func sniffServerName(r io.Reader) (string, io.Reader) {
+	buf := &bytes.Buffer{}
+	name := ""
+	err := tls.Server(&sniffConn{r: io.TeeReader(r, buf)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			name = hello.ServerName
+			return nil, errSniffed
+		},
+	}).Handshake()
+	if err != nil && errors.Cause(err) != errSniffed && name == "" {
+		logrus.WithError(err).Debug("sniff client hello")
+	}
+	return name, io.MultiReader(buf, r)
+}
+
+// sniffConn only reads, the alert tls.Server sends on the aborted handshake goes nowhere
+type sniffConn struct {
+	r io.Reader
+}
+
+func (c *sniffConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c *sniffConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *sniffConn) Close() error                       { return nil }
+func (c *sniffConn) LocalAddr() net.Addr                { return nil }
+func (c *sniffConn) RemoteAddr() net.Addr               { return nil }
+func (c *sniffConn) SetDeadline(t time.Time) error      { return nil }
+func (c *sniffConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *sniffConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+)
+
+// recordConn passes the first write on to sent before writing it
+type recordConn struct {
+	net.Conn
+	sent chan []byte
+}
+
+func (c *recordConn) Write(p []byte) (int, error) {
+	select {
+	case c.sent <- append([]byte{}, p...):
+	default:
+	}
+	return c.Conn.Write(p)
+}
+
+func TestSniffServerName(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverName string
+	}{
+		{"sni", "example.com"},
+		{"no sni", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			sent := make(chan []byte, 1)
+			go func() {
+				// fails once the pipe is closed, nobody answers the hello
+				tls.Client(&recordConn{Conn: client, sent: sent}, &tls.Config{
+					ServerName:         tt.serverName,
+					InsecureSkipVerify: true,
+				}).Handshake()
+				client.Close()
+			}()
+
+			name, r := sniffServerName(bufio.NewReader(server))
+			if name != tt.serverName {
+				t.Errorf("server name %q, want %q", name, tt.serverName)
+			}
+			hello := <-sent
+			conn := &peekedConn{Conn: server, r: r}
+			replayed := make([]byte, len(hello))
+			if _, err := io.ReadFull(conn, replayed); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(replayed, hello) {
+				t.Errorf("replayed %d bytes differ from the client hello", len(replayed))
+			}
+		})
+	}
+}
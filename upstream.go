@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elazarl/goproxy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	xproxy "golang.org/x/net/proxy"
+)
+
+const (
+	// always there, no proxies
+	UpstreamDirect = "direct"
+	// --upstream, direct if it is not set, used when no route matches
+	UpstreamDefault = "default"
+)
+
+const (
+	healthCheckDefaultInterval = 30
+	healthCheckDefaultTimeout  = 5
+	// bodies up to this size are kept to resend them to the next upstream
+	failoverMaxBody = 1 << 20
+)
+
+// Upstream is a chain of proxies dialed in order, http(s):// or socks5://, empty is direct
+type Upstream struct {
+	Name  string   `json:"name"`
+	Chain []string `json:"chain"`
+
+	// tunnels, through the whole chain
+	dial func(network, addr string) (net.Conn, error)
+	// requests, sent to the last proxy if it is an http one
+	transport *http.Transport
+}
+
+func (u *Upstream) compile() error {
+	var dialer xproxy.Dialer = xproxy.Direct
+	// reaches the last proxy of the chain
+	prefix := dialer
+	var last *url.URL
+	for _, raw := range u.Chain {
+		p, err := url.Parse(raw)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		prefix, last = dialer, nil
+		switch p.Scheme {
+		case "socks5", "socks5h":
+			if dialer, err = xproxy.FromURL(p, dialer); err != nil {
+				return errors.WithStack(err)
+			}
+		case "http", "https":
+			dialer, last = &httpConnectDialer{proxy: p, forward: dialer}, p
+		default:
+			return errors.Errorf("unsupported proxy %q", raw)
+		}
+	}
+	u.dial = dialer.Dial
+	u.transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, Dial: dialer.Dial}
+	if last != nil {
+		// the last http proxy gets absolute urls, it is likely burp
+		u.transport.Proxy, u.transport.Dial = http.ProxyURL(last), prefix.Dial
+	}
+	return nil
+}
+
+// Route sends matching traffic to the first healthy of its upstreams
+type Route struct {
+	Name string `json:"name"`
+	// same rules as the global scope, empty is everything
+	Scope []*ScopeRule `json:"scope"`
+	// names tried in order
+	Upstreams []string `json:"upstreams"`
+
+	scope *Scope
+}
+
+type HealthCheck struct {
+	// seconds between checks, negative disables them
+	Interval int `json:"interval"`
+	Timeout  int `json:"timeout"`
+	// host:port dialed through every upstream, empty only dials the first proxy
+	Target string `json:"target,omitempty"`
+}
+
+type UpstreamHealth struct {
+	Name      string    `json:"name"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Router picks the upstream of each request and tunnel by the first matching route
+type Router struct {
+	mux         *sync.RWMutex
+	Upstreams   []*Upstream `json:"upstreams"`
+	Routes      []*Route    `json:"routes"`
+	HealthCheck HealthCheck `json:"health_check"`
+
+	filename  string
+	fallback  *Upstream
+	upstreams map[string]*Upstream
+	health    map[string]*UpstreamHealth
+}
+
+// NewRouter loads routes from filename, fallback is the --upstream proxy url
+func NewRouter(filename, fallback string) (*Router, error) {
+	r := &Router{mux: &sync.RWMutex{}, filename: filename, health: make(map[string]*UpstreamHealth)}
+	r.fallback = &Upstream{Name: UpstreamDefault, Chain: []string{}}
+	if fallback != "" {
+		r.fallback.Chain = []string{fallback}
+	}
+	if err := r.fallback.compile(); err != nil {
+		return nil, errors.Wrap(err, "upstream")
+	}
+	c := &Router{}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+	if err == nil {
+		if err = json.Unmarshal(data, c); err != nil {
+			return nil, errors.Wrapf(err, "parse %s", filename)
+		}
+	}
+	if err = r.set(c.Upstreams, c.Routes, c.HealthCheck); err != nil {
+		return nil, errors.Wrapf(err, "routes %s", filename)
+	}
+	go r.healthLoop()
+	return r, nil
+}
+
+func (r *Router) set(upstreams []*Upstream, routes []*Route, check HealthCheck) error {
+	if upstreams == nil {
+		upstreams = []*Upstream{}
+	}
+	if routes == nil {
+		routes = []*Route{}
+	}
+	if check.Interval == 0 {
+		check.Interval = healthCheckDefaultInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = healthCheckDefaultTimeout
+	}
+	byName := map[string]*Upstream{
+		UpstreamDirect:  {Name: UpstreamDirect, Chain: []string{}},
+		UpstreamDefault: r.fallback,
+	}
+	byName[UpstreamDirect].compile()
+	for _, u := range upstreams {
+		if _, ok := byName[u.Name]; ok || u.Name == "" {
+			return errors.Errorf("upstream name %q is empty or taken", u.Name)
+		}
+		if u.Chain == nil {
+			u.Chain = []string{}
+		}
+		if err := u.compile(); err != nil {
+			return errors.Wrapf(err, "upstream %s", u.Name)
+		}
+		byName[u.Name] = u
+	}
+	for _, route := range routes {
+		if len(route.Upstreams) == 0 {
+			return errors.Errorf("route %s: no upstreams", route.Name)
+		}
+		for _, name := range route.Upstreams {
+			if _, ok := byName[name]; !ok {
+				return errors.Errorf("route %s: unknown upstream %q", route.Name, name)
+			}
+		}
+		route.scope = &Scope{mux: &sync.RWMutex{}}
+		if err := route.scope.set(route.Scope); err != nil {
+			return errors.Wrapf(err, "route %s", route.Name)
+		}
+	}
+	r.mux.Lock()
+	r.Upstreams, r.Routes, r.HealthCheck, r.upstreams = upstreams, routes, check, byName
+	for name := range byName {
+		if _, ok := r.health[name]; !ok {
+			r.health[name] = &UpstreamHealth{Name: name, Healthy: true}
+		}
+	}
+	r.mux.Unlock()
+	return nil
+}
+
+// Set replaces the table and saves it
+func (r *Router) Set(upstreams []*Upstream, routes []*Route, check HealthCheck) error {
+	// the table sent back as it was served keeps the stored passwords
+	r.mux.RLock()
+	for _, u := range upstreams {
+		if old, ok := r.upstreams[u.Name]; ok {
+			for i, raw := range u.Chain {
+				if i < len(old.Chain) && redactURL(old.Chain[i]) == raw {
+					u.Chain[i] = old.Chain[i]
+				}
+			}
+		}
+	}
+	r.mux.RUnlock()
+	if err := r.set(upstreams, routes, check); err != nil {
+		return errors.WithStack(err)
+	}
+	data, err := json.MarshalIndent(r.Snapshot(), "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ioutil.WriteFile(r.filename, data, 0644))
+}
+
+func (r *Router) Snapshot() Router {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return Router{Upstreams: r.Upstreams, Routes: r.Routes, HealthCheck: r.HealthCheck}
+}
+
+// Redacted is the snapshot with proxy passwords masked
+func (r *Router) Redacted() Router {
+	res := r.Snapshot()
+	upstreams := make([]*Upstream, 0, len(res.Upstreams))
+	for _, u := range res.Upstreams {
+		chain := make([]string, 0, len(u.Chain))
+		for _, raw := range u.Chain {
+			chain = append(chain, redactURL(raw))
+		}
+		upstreams = append(upstreams, &Upstream{Name: u.Name, Chain: chain})
+	}
+	res.Upstreams = upstreams
+	return res
+}
+
+// Health of every upstream, by name
+func (r *Router) Health() []UpstreamHealth {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	res := make([]UpstreamHealth, 0, len(r.upstreams))
+	for name := range r.upstreams {
+		res = append(res, *r.health[name])
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+func (r *Router) setHealth(u *Upstream, err error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	h, ok := r.health[u.Name]
+	if !ok {
+		return
+	}
+	if err != nil && h.Healthy {
+		logrus.WithError(err).Warnf("upstream %s is down", u.Name)
+	} else if err == nil && !h.Healthy {
+		logrus.Printf("upstream %s is up", u.Name)
+	}
+	h.Healthy, h.LastCheck, h.Error = err == nil, time.Now(), ""
+	if err != nil {
+		h.Error = err.Error()
+	}
+}
+
+// up marks an upstream which was down and worked again
+func (r *Router) up(u *Upstream) {
+	r.mux.RLock()
+	h, ok := r.health[u.Name]
+	down := ok && !h.Healthy
+	r.mux.RUnlock()
+	if down {
+		r.setHealth(u, nil)
+	}
+}
+
+// candidates are the upstreams of the first matching route, healthy ones first
+func (r *Router) candidates(match func(s *Scope) bool) []*Upstream {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	names := []string{UpstreamDefault}
+	for _, route := range r.Routes {
+		if match(route.scope) {
+			names = route.Upstreams
+			break
+		}
+	}
+	healthy, down := make([]*Upstream, 0, len(names)), make([]*Upstream, 0)
+	for _, name := range names {
+		u := r.upstreams[name]
+		if r.health[name].Healthy {
+			healthy = append(healthy, u)
+		} else {
+			down = append(down, u)
+		}
+	}
+	// all down is tried anyway
+	return append(healthy, down...)
+}
+
+// Dial is proxy.ConnectDial, a CONNECT tunnel to port 80 is taken for plain http (ws), others for tls
+func (r *Router) Dial(network, addr string) (net.Conn, error) {
+	protocol := "https"
+	if _, port, err := net.SplitHostPort(addr); err == nil && port == "80" {
+		protocol = "http"
+	}
+	return r.DialProtocol(protocol, network, addr)
+}
+
+// DialProtocol routes a tunnel by protocol and host, protocol is the scheme of the traffic going through it
+func (r *Router) DialProtocol(protocol, network, addr string) (net.Conn, error) {
+	var err error
+	for _, u := range r.candidates(func(s *Scope) bool { return s.HostInScope(protocol, addr) }) {
+		var conn net.Conn
+		if conn, err = u.dial(network, addr); err == nil {
+			r.up(u)
+			return conn, nil
+		}
+		if len(u.Chain) > 0 {
+			r.setHealth(u, err)
+		}
+	}
+	return nil, errors.WithStack(err)
+}
+
+// RoundTrip sends req through the upstreams of its route until one answers
+func (r *Router) RoundTrip(req *http.Request) (*http.Response, error) {
+	upstreams := r.candidates(func(s *Scope) bool { return s.InScope(req.Method, req.URL) })
+	retry := rewindableBody(req)
+	var err error
+	for i, u := range upstreams {
+		if i > 0 {
+			if !retry {
+				break
+			}
+			if req.GetBody != nil {
+				if req.Body, err = req.GetBody(); err != nil {
+					return nil, errors.WithStack(err)
+				}
+			}
+			logrus.Printf("--> %s %s (upstream %s)", req.Method, urlColor(req.URL), u.Name)
+		}
+		var resp *http.Response
+		if resp, err = u.transport.RoundTrip(req); err == nil {
+			r.up(u)
+			return resp, nil
+		}
+		if len(u.Chain) > 0 {
+			r.setHealth(u, err)
+		}
+	}
+	return nil, err
+}
+
+// rewindableBody makes GetBody for small bodies, big ones are not resent
+func rewindableBody(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return true
+	}
+	if req.ContentLength < 0 || req.ContentLength > failoverMaxBody {
+		return false
+	}
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return false
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return true
+}
+
+// requestHandler sends the request through the router
+func (r *Router) requestHandler(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+	ctx.RoundTripper = goproxy.RoundTripperFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Response, error) {
+		return r.RoundTrip(req)
+	})
+	return req, nil
+}
+
+func (r *Router) healthLoop() {
+	for {
+		r.mux.RLock()
+		check := r.HealthCheck
+		upstreams := make([]*Upstream, 0, len(r.upstreams))
+		for _, u := range r.upstreams {
+			upstreams = append(upstreams, u)
+		}
+		r.mux.RUnlock()
+		if check.Interval < 0 {
+			time.Sleep(healthCheckDefaultInterval * time.Second)
+			continue
+		}
+		for _, u := range upstreams {
+			if len(u.Chain) == 0 && check.Target == "" {
+				continue
+			}
+			go func(u *Upstream) {
+				r.setHealth(u, r.check(u, check))
+			}(u)
+		}
+		time.Sleep(time.Duration(check.Interval) * time.Second)
+	}
+}
+
+func (r *Router) check(u *Upstream, check HealthCheck) error {
+	timeout := time.Duration(check.Timeout) * time.Second
+	if check.Target == "" {
+		p, err := url.Parse(u.Chain[0])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		conn, err := net.DialTimeout("tcp", proxyHostPort(p), timeout)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return conn.Close()
+	}
+	done := make(chan error, 1)
+	go func() {
+		conn, err := u.dial("tcp", check.Target)
+		if err == nil {
+			conn.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return errors.WithStack(err)
+	case <-time.After(timeout):
+		return errors.Errorf("%s: timeout", check.Target)
+	}
+}
+
+func proxyHostPort(p *url.URL) string {
+	if p.Port() != "" {
+		return p.Host
+	}
+	switch p.Scheme {
+	case "https":
+		return net.JoinHostPort(p.Hostname(), "443")
+	case "socks5", "socks5h":
+		return net.JoinHostPort(p.Hostname(), "1080")
+	default:
+		return net.JoinHostPort(p.Hostname(), "80")
+	}
+}
+
+// httpConnectDialer opens tunnels with CONNECT through an http proxy reached by forward
+type httpConnectDialer struct {
+	proxy   *url.URL
+	forward xproxy.Dialer
+}
+
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.forward.Dial(network, proxyHostPort(d.proxy))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if d.proxy.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: d.proxy.Hostname(), InsecureSkipVerify: true})
+	}
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: make(http.Header)}
+	if user := d.proxy.User; user != nil {
+		pass, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+pass)))
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, errors.WithStack(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, errors.WithStack(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, errors.Errorf("%s: CONNECT %s: %s", d.proxy.Host, addr, resp.Status)
+	}
+	// the proxy may have sent more than the answer
+	return &peekedConn{Conn: conn, r: br}, nil
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	WSOpContinuation = 0x0
+	WSOpText         = 0x1
+	WSOpBinary       = 0x2
+	WSOpClose        = 0x8
+	WSOpPing         = 0x9
+	WSOpPong         = 0xa
+
+	WSDirClient = "client" // client -> server
+	WSDirServer = "server" // server -> client
+
+	wsTokenHeader = "X-Anotherproxy-Ws-Token"
+	wsGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// frames are read whole, bigger ones close the conversation
+	wsMaxFrameSize = 16 << 20
+	// goproxy connects to the relay right after the redirect, tokens left are of failed requests
+	wsPendingTTL = time.Minute
+)
+
+func isWebSocketRequest(req *http.Request) bool {
+	return headerContains(req.Header, "Connection", "upgrade") &&
+		headerContains(req.Header, "Upgrade", "websocket")
+}
+
+func headerContains(header http.Header, name string, value string) bool {
+	for _, v := range header[name] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(value, strings.TrimSpace(s)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type WSFrame struct {
+	Session   int64     `json:"session"`
+	Direction string    `json:"direction"`
+	Opcode    int       `json:"opcode"`
+	Fin       bool      `json:"fin"`
+	Time      time.Time `json:"time"`
+	Text      string    `json:"text,omitempty"`
+	Payload   []byte    `json:"payload,omitempty"`
+
+	raw []byte
+}
+
+func (f *WSFrame) Data() []byte {
+	if f.Text != "" {
+		return []byte(f.Text)
+	}
+	return f.Payload
+}
+
+// readWSFrame reads one frame, raw keeps the frame bytes as is, Payload is unmasked
+func readWSFrame(r *bufio.Reader) (*WSFrame, error) {
+	header := make([]byte, 2, 14)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	frame := &WSFrame{
+		Fin:    header[0]&0x80 != 0,
+		Opcode: int(header[0] & 0x0f),
+	}
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		header = append(header, ext...)
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		header = append(header, ext...)
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > wsMaxFrameSize {
+		return nil, errors.Errorf("websocket frame of %d bytes is over %d", length, wsMaxFrameSize)
+	}
+	var mask []byte
+	if masked {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return nil, err
+		}
+		header = append(header, mask...)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	frame.raw = append(header, payload...)
+	if masked {
+		unmasked := make([]byte, length)
+		for i := range payload {
+			unmasked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = unmasked
+	}
+	frame.Payload = payload
+	return frame, nil
+}
+
+// serverFrameBytes encodes unmasked server -> client frame
+func serverFrameBytes(opcode int, fin bool, payload []byte) []byte {
+	b := make([]byte, 0, len(payload)+10)
+	first := byte(opcode & 0x0f)
+	if fin {
+		first |= 0x80
+	}
+	b = append(b, first)
+	switch l := len(payload); {
+	case l < 126:
+		b = append(b, byte(l))
+	case l <= 0xffff:
+		b = append(b, 126, byte(l>>8), byte(l))
+	default:
+		ext := make([]byte, 8)
+		binary.BigEndian.PutUint64(ext, uint64(l))
+		b = append(append(b, 127), ext...)
+	}
+	return append(b, payload...)
+}
+
+type WSConversation struct {
+	ID      string      `json:"id"`
+	Session int64       `json:"session"`
+	Hash    string      `json:"hash"`
+	URL     string      `json:"url"`
+	Time    time.Time   `json:"time"`
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+}
+
+// WebSocketStore keeps every conversation in own jsonl file,
+// first line is WSConversation, the rest are WSFrame
+type WebSocketStore struct {
+	path string
+}
+
+func NewWebSocketStore() (*WebSocketStore, error) {
+	if err := os.MkdirAll(options.WebSocketPath(), 0700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &WebSocketStore{path: options.WebSocketPath()}, nil
+}
+
+func (s *WebSocketStore) filename(id string) string {
+	return filepath.Join(s.path, id+".jsonl")
+}
+
+func (s *WebSocketStore) Create(conv *WSConversation) (*WSRecorder, error) {
+	conv.ID = fmt.Sprintf("%d_%d_%s", conv.Time.UnixNano(), conv.Session, conv.Hash)
+	f, err := os.OpenFile(s.filename(conv.ID), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	rec := &WSRecorder{mux: &sync.Mutex{}, f: f, enc: json.NewEncoder(f), conv: conv}
+	if err = rec.enc.Encode(conv); err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	return rec, nil
+}
+
+// Conversations returns conversations sorted by time, session < 0 means any session
+func (s *WebSocketStore) Conversations(session int64, hash string) ([]*WSConversation, error) {
+	files, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	res := make([]*WSConversation, 0, len(files))
+	for _, fi := range files {
+		id := strings.TrimSuffix(fi.Name(), ".jsonl")
+		parts := strings.SplitN(id, "_", 3)
+		if len(parts) != 3 || id == fi.Name() {
+			continue
+		}
+		if hash != "" && parts[2] != hash {
+			continue
+		}
+		if sess, _ := strconv.ParseInt(parts[1], 10, 64); session >= 0 && sess != session {
+			continue
+		}
+		conv, _, err := s.Load(id, false)
+		if err != nil {
+			logrus.WithError(err).WithField("id", id).Warn("load websocket conversation")
+			continue
+		}
+		res = append(res, conv)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Time.Before(res[j].Time) })
+	return res, nil
+}
+
+func (s *WebSocketStore) Load(id string, withFrames bool) (*WSConversation, []*WSFrame, error) {
+	f, err := os.Open(s.filename(filepath.Base(id)))
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	conv := &WSConversation{}
+	if err = dec.Decode(conv); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	frames := make([]*WSFrame, 0)
+	for withFrames {
+		frame := &WSFrame{}
+		if err = dec.Decode(frame); err == io.EOF {
+			break
+		} else if err != nil {
+			// conversation could be still in progress
+			logrus.WithError(err).WithField("id", id).Warn("decode websocket frame")
+			break
+		}
+		frames = append(frames, frame)
+	}
+	return conv, frames, nil
+}
+
+type WSRecorder struct {
+	mux  *sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+	conv *WSConversation
+	// opcode of unfinished fragmented message by direction
+	fragmented map[string]int
+}
+
+func (r *WSRecorder) Record(direction string, frame *WSFrame) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.fragmented == nil {
+		r.fragmented = make(map[string]int)
+	}
+	frame.Session = r.conv.Session
+	frame.Direction = direction
+	frame.Time = time.Now()
+
+	opcode := frame.Opcode
+	if opcode == WSOpContinuation {
+		opcode = r.fragmented[direction]
+	} else if !frame.Fin {
+		r.fragmented[direction] = opcode
+	}
+	if opcode == WSOpText && utf8.Valid(frame.Payload) && len(frame.Payload) > 0 {
+		frame.Text = string(frame.Payload)
+		frame.Payload = nil
+	}
+	if err := r.enc.Encode(frame); err != nil {
+		logrus.WithError(err).Error("record websocket frame")
+	}
+}
+
+func (r *WSRecorder) Close() error {
+	return r.f.Close()
+}
+
+type wsPending struct {
+	session int64
+	url     *url.URL
+	mode    CacheMode
+	created time.Time
+}
+
+// WebSocketRelay sits between goproxy and the target site: goproxy just copies
+// upgraded connections, so MITM'd websocket requests are redirected here to see the frames
+type WebSocketRelay struct {
+	mux     *sync.Mutex
+	pending map[string]*wsPending
+	store   *WebSocketStore
+	dial    func(protocol, network, addr string) (net.Conn, error)
+
+	plain net.Listener
+	tls   net.Listener
+}
+
+func NewWebSocketRelay(ca *CA, dial func(protocol, network, addr string) (net.Conn, error)) (*WebSocketRelay, error) {
+	store, err := NewWebSocketStore()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	r := &WebSocketRelay{
+		mux:     &sync.Mutex{},
+		pending: make(map[string]*wsPending),
+		store:   store,
+		dial:    dial,
+	}
+	if r.plain, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// goproxy connects with InsecureSkipVerify, any certificate is fine
+	r.tls, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{ca.Certificate}})
+	if err != nil {
+		r.plain.Close()
+		return nil, errors.WithStack(err)
+	}
+	go r.serve(r.plain)
+	go r.serve(r.tls)
+	return r, nil
+}
+
+// Redirect points websocket upgrade request to the relay
+func (r *WebSocketRelay) Redirect(req *http.Request, session int64, mode CacheMode) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		logrus.WithError(err).Error("websocket token")
+		return
+	}
+	token := hex.EncodeToString(buf)
+	u := *req.URL
+	if u.Host == "" {
+		u.Host = req.Host
+	}
+	r.mux.Lock()
+	for t, p := range r.pending {
+		if time.Since(p.created) > wsPendingTTL {
+			delete(r.pending, t)
+		}
+	}
+	r.pending[token] = &wsPending{session: session, url: &u, mode: mode, created: time.Now()}
+	r.mux.Unlock()
+
+	req.Header.Set(wsTokenHeader, token)
+	if req.Host == "" {
+		req.Host = req.URL.Host
+	}
+	if u.Scheme == "https" || u.Scheme == "wss" {
+		req.URL.Host = r.tls.Addr().String()
+	} else {
+		req.URL.Host = r.plain.Addr().String()
+	}
+}
+
+// IsRelayAddr is used to dial the relay directly even with upstream proxy
+func (r *WebSocketRelay) IsRelayAddr(addr string) bool {
+	return addr == r.plain.Addr().String() || addr == r.tls.Addr().String()
+}
+
+func (r *WebSocketRelay) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			logrus.WithError(err).Error("websocket relay accept")
+			return
+		}
+		go func() {
+			defer conn.Close()
+			if err := r.handle(conn); err != nil {
+				logrus.WithError(err).Warn("websocket relay")
+			}
+		}()
+	}
+}
+
+func (r *WebSocketRelay) handle(clientConn net.Conn) error {
+	client := bufio.NewReader(clientConn)
+	req, err := http.ReadRequest(client)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	token := req.Header.Get(wsTokenHeader)
+	r.mux.Lock()
+	pending, ok := r.pending[token]
+	delete(r.pending, token)
+	r.mux.Unlock()
+	if !ok {
+		// goproxy tries plain round trip after websocket is done, reject it
+		resp := &http.Response{StatusCode: http.StatusForbidden, ProtoMajor: 1, ProtoMinor: 1, Request: req}
+		return errors.WithStack(resp.Write(clientConn))
+	}
+	req.Header.Del(wsTokenHeader)
+	// frames are recorded as is, so don't let compression to be negotiated
+	req.Header.Del("Sec-WebSocket-Extensions")
+	req.URL = pending.url
+	req.RequestURI = ""
+
+	reqDTO := NewRequestDTO(req)
+	if reqDTO == nil {
+		return errors.New("read websocket request")
+	}
+	if pending.mode.Replay() {
+		convs, err := r.store.Conversations(-1, reqDTO.Hash())
+		if err == nil && len(convs) > 0 {
+			logrus.Printf("[%d] ws replay %s", pending.session, urlColor(req.URL))
+			return r.replay(convs[len(convs)-1], req, client, clientConn)
+		}
+	}
+	if pending.mode == CacheModeOffline {
+		resp := &http.Response{StatusCode: http.StatusGatewayTimeout, ProtoMajor: 1, ProtoMinor: 1, Request: req}
+		return errors.WithStack(resp.Write(clientConn))
+	}
+	return r.record(pending.session, reqDTO, client, clientConn)
+}
+
+func (r *WebSocketRelay) record(session int64, reqDTO *RequestDTO, client *bufio.Reader, clientConn net.Conn) error {
+	req := reqDTO.Request
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		if req.URL.Scheme == "https" || req.URL.Scheme == "wss" {
+			addr = net.JoinHostPort(req.URL.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(req.URL.Hostname(), "80")
+		}
+	}
+	targetConn, err := r.dial(req.URL.Scheme, "tcp", addr)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if req.URL.Scheme == "https" || req.URL.Scheme == "wss" {
+		targetConn = tls.Client(targetConn, &tls.Config{ServerName: req.URL.Hostname(), InsecureSkipVerify: true})
+	}
+	defer targetConn.Close()
+
+	if err = req.Write(targetConn); err != nil {
+		return errors.WithStack(err)
+	}
+	target := bufio.NewReader(targetConn)
+	resp, err := http.ReadResponse(target, req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err = resp.Write(clientConn); err != nil {
+		return errors.WithStack(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil
+	}
+
+	rec, err := r.store.Create(&WSConversation{
+		Session: session,
+		Hash:    reqDTO.Hash(),
+		URL:     req.URL.String(),
+		Time:    time.Now(),
+		Status:  resp.StatusCode,
+		Header:  resp.Header.Clone(),
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer rec.Close()
+
+	errChan := make(chan error, 2)
+	pump := func(direction string, dst io.Writer, src *bufio.Reader) {
+		for {
+			frame, err := readWSFrame(src)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			if _, err = dst.Write(frame.raw); err != nil {
+				errChan <- err
+				return
+			}
+			rec.Record(direction, frame)
+		}
+	}
+	go pump(WSDirClient, targetConn, client)
+	go pump(WSDirServer, clientConn, target)
+	if err = <-errChan; err != io.EOF {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// replay answers with server frames recorded after the same client frame
+func (r *WebSocketRelay) replay(conv *WSConversation, req *http.Request, client *bufio.Reader, clientConn net.Conn) error {
+	_, frames, err := r.store.Load(conv.ID, true)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	key := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + wsGUID))
+	resp := &http.Response{
+		StatusCode: http.StatusSwitchingProtocols,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     conv.Header.Clone(),
+		Request:    req,
+	}
+	resp.Header.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(key[:]))
+	if err = resp.Write(clientConn); err != nil {
+		return errors.WithStack(err)
+	}
+
+	sendServerFrames := func() ([]*WSFrame, error) {
+		for len(frames) > 0 && frames[0].Direction == WSDirServer {
+			if _, err := clientConn.Write(serverFrameBytes(frames[0].Opcode, frames[0].Fin, frames[0].Data())); err != nil {
+				return nil, err
+			}
+			frames = frames[1:]
+		}
+		return frames, nil
+	}
+	if frames, err = sendServerFrames(); err != nil {
+		return errors.WithStack(err)
+	}
+	for {
+		frame, err := readWSFrame(client)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+		switch frame.Opcode {
+		case WSOpClose:
+			_, err = clientConn.Write(serverFrameBytes(WSOpClose, true, frame.Payload))
+			return errors.WithStack(err)
+		case WSOpPing:
+			if _, err = clientConn.Write(serverFrameBytes(WSOpPong, true, frame.Payload)); err != nil {
+				return errors.WithStack(err)
+			}
+			continue
+		}
+		// skip recorded client frame and answer what server answered to it
+		if len(frames) > 0 && frames[0].Direction == WSDirClient {
+			frames = frames[1:]
+		}
+		if frames, err = sendServerFrames(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestReadWSFrame(t *testing.T) {
+	mask := []byte{1, 2, 3, 4}
+	masked := func(payload string) []byte {
+		b := []byte(payload)
+		for i := range b {
+			b[i] ^= mask[i%4]
+		}
+		return b
+	}
+	big := bytes.Repeat([]byte("x"), 70000)
+
+	tests := []struct {
+		name    string
+		frame   []byte
+		opcode  int
+		fin     bool
+		payload []byte
+		err     bool
+	}{
+		{
+			name:    "short text",
+			frame:   serverFrameBytes(WSOpText, true, []byte("hello")),
+			opcode:  WSOpText,
+			fin:     true,
+			payload: []byte("hello"),
+		},
+		{
+			name:    "16 bit length",
+			frame:   serverFrameBytes(WSOpBinary, false, big[:300]),
+			opcode:  WSOpBinary,
+			payload: big[:300],
+		},
+		{
+			name:    "64 bit length",
+			frame:   serverFrameBytes(WSOpBinary, true, big),
+			opcode:  WSOpBinary,
+			fin:     true,
+			payload: big,
+		},
+		{
+			name:    "masked client frame",
+			frame:   concat([]byte{0x81, 0x80 | 5}, mask, masked("hello")),
+			opcode:  WSOpText,
+			fin:     true,
+			payload: []byte("hello"),
+		},
+		{
+			name:  "forged 64 bit length",
+			frame: []byte{0x82, 127, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			err:   true,
+		},
+		{
+			name:  "over max frame size",
+			frame: []byte{0x82, 127, 0, 0, 0, 0, 0x01, 0, 0, 1},
+			err:   true,
+		},
+		{
+			name:  "truncated payload",
+			frame: []byte{0x81, 5, 'h', 'e'},
+			err:   true,
+		},
+		{
+			name:  "truncated length",
+			frame: []byte{0x81, 126, 1},
+			err:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := readWSFrame(bufio.NewReader(bytes.NewReader(tt.frame)))
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if frame.Opcode != tt.opcode || frame.Fin != tt.fin {
+				t.Errorf("opcode %d fin %v, want %d %v", frame.Opcode, frame.Fin, tt.opcode, tt.fin)
+			}
+			if !bytes.Equal(frame.Payload, tt.payload) {
+				t.Errorf("payload of %d bytes, want %d", len(frame.Payload), len(tt.payload))
+			}
+			if !bytes.Equal(frame.raw, tt.frame) {
+				t.Errorf("raw frame is not kept as is")
+			}
+		})
+	}
+}